@@ -0,0 +1,133 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/config"
+)
+
+// loadConfig reads the jb configuration file. If path is empty, the default
+// location is used, which is allowed to not exist.
+func loadConfig(path string) (config.Config, error) {
+	if path != "" {
+		return config.Load(path)
+	}
+
+	path, err := config.DefaultPath()
+	if err != nil {
+		return config.Config{}, nil
+	}
+
+	c, err := config.Load(path)
+	if os.IsNotExist(err) {
+		return config.Config{}, nil
+	}
+	return c, err
+}
+
+// retryFlags are the command line flags configuring pkg.RetryPolicy. Flags
+// passed explicitly take precedence over the configuration file.
+type retryFlags struct {
+	pkg.RetryPolicy
+	set map[string]bool
+}
+
+func (f *retryFlags) register(a *kingpin.Application) {
+	f.RetryPolicy = pkg.DefaultRetryPolicy
+	f.set = make(map[string]bool)
+
+	mark := func(name string) kingpin.Action {
+		return func(*kingpin.ParseContext) error {
+			f.set[name] = true
+			return nil
+		}
+	}
+
+	a.Flag("retries", "How often to retry failed downloads and git fetches.").
+		Default("3").Action(mark("retries")).IntVar(&f.Retries)
+	a.Flag("retry-backoff", "Wait before the first retry. Doubles on every further attempt.").
+		Default("1s").Action(mark("retry-backoff")).DurationVar(&f.Backoff)
+	a.Flag("retry-max-backoff", "Maximum wait between retries. Give up if the server asks to wait longer.").
+		Default("1m").Action(mark("retry-max-backoff")).DurationVar(&f.MaxBackoff)
+}
+
+// policy merges the flags with the configuration file
+func (f *retryFlags) policy(c config.Retry) pkg.RetryPolicy {
+	p := f.RetryPolicy
+
+	if c.Retries != nil && !f.set["retries"] {
+		p.Retries = *c.Retries
+	}
+	if c.Backoff != nil && !f.set["retry-backoff"] {
+		p.Backoff = time.Duration(*c.Backoff)
+	}
+	if c.MaxBackoff != nil && !f.set["retry-max-backoff"] {
+		p.MaxBackoff = time.Duration(*c.MaxBackoff)
+	}
+
+	return p
+}
+
+// httpFlags are the command line flags configuring pkg.HTTPSettings. Flags
+// passed explicitly take precedence over the configuration file.
+type httpFlags struct {
+	pkg.HTTPSettings
+}
+
+func (f *httpFlags) register(a *kingpin.Application) {
+	a.Flag("proxy", "Proxy for all downloads and git commands. Defaults to $HTTPS_PROXY.").
+		StringVar(&f.Proxy)
+	a.Flag("no-proxy", "Comma separated hosts to connect to without proxy. Defaults to $NO_PROXY.").
+		StringVar(&f.NoProxy)
+	a.Flag("ca-file", "PEM bundle of the certificate authorities to trust instead of the system ones.").
+		StringVar(&f.CAFile)
+	a.Flag("client-cert", "PEM client certificate for mutual TLS.").
+		StringVar(&f.CertFile)
+	a.Flag("client-key", "PEM key of --client-cert. Defaults to the certificate file.").
+		StringVar(&f.KeyFile)
+}
+
+// settings merges the flags with the configuration file
+func (f *httpFlags) settings(c config.HTTP) pkg.HTTPSettings {
+	s := f.HTTPSettings
+
+	merge := func(flag *string, conf string) {
+		if *flag == "" {
+			*flag = conf
+		}
+	}
+	merge(&s.Proxy, c.Proxy)
+	merge(&s.NoProxy, c.NoProxy)
+	merge(&s.CAFile, c.CAFile)
+	merge(&s.CertFile, c.CertFile)
+	merge(&s.KeyFile, c.KeyFile)
+
+	return s
+}
+
+// rewrites converts the rewrite rules of the configuration file
+func rewrites(c []config.Rewrite) ([]pkg.Rewrite, error) {
+	var rules []pkg.Rewrite
+	for _, r := range c {
+		rules = append(rules, pkg.Rewrite{From: r.From, To: r.To})
+	}
+	return rules, pkg.CheckRewrites(rules)
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/config"
+)
+
+func TestRetryFlagsPolicy(t *testing.T) {
+	retries := 7
+	backoff := config.Duration(5 * time.Second)
+	conf := config.Retry{Retries: &retries, Backoff: &backoff}
+
+	tests := []struct {
+		name string
+		args []string
+		conf config.Retry
+		want pkg.RetryPolicy
+	}{
+		{
+			name: "Defaults",
+			want: pkg.DefaultRetryPolicy,
+		},
+		{
+			name: "Config",
+			conf: conf,
+			want: pkg.RetryPolicy{Retries: 7, Backoff: 5 * time.Second, MaxBackoff: time.Minute},
+		},
+		{
+			name: "FlagsOverrideConfig",
+			args: []string{"--retries=0"},
+			conf: conf,
+			want: pkg.RetryPolicy{Retries: 0, Backoff: 5 * time.Second, MaxBackoff: time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := kingpin.New("jb", "")
+			f := retryFlags{}
+			f.register(a)
+
+			_, err := a.Parse(tt.args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, f.policy(tt.conf))
+		})
+	}
+}
+
+func TestHTTPFlagsSettings(t *testing.T) {
+	conf := config.HTTP{Proxy: "proxy.corp:3128", CAFile: "/etc/corp-ca.pem"}
+
+	a := kingpin.New("jb", "")
+	f := httpFlags{}
+	f.register(a)
+
+	_, err := a.Parse([]string{"--proxy=other.corp:8080", "--client-cert=client.pem"})
+	require.NoError(t, err)
+	assert.Equal(t, pkg.HTTPSettings{
+		Proxy:    "other.corp:8080",
+		CAFile:   "/etc/corp-ca.pem",
+		CertFile: "client.pem",
+	}, f.settings(conf))
+}
+
+func TestRewrites(t *testing.T) {
+	rules, err := rewrites([]config.Rewrite{{From: "github.com/*", To: "https://git-mirror.corp/github/*"}})
+	require.NoError(t, err)
+	assert.Equal(t, []pkg.Rewrite{{From: "github.com/*", To: "https://git-mirror.corp/github/*"}}, rules)
+
+	_, err = rewrites([]config.Rewrite{{From: "github.com/*"}})
+	assert.Error(t, err)
+}
@@ -26,11 +26,13 @@ import (
 )
 
 func initCommand(dir string) int {
-	exists, err := jsonnetfile.Exists(jsonnetfile.File)
+	existing, err := jsonnetfile.Find(dir)
+	kingpin.FatalIfError(err, "Failed to check for jsonnetfile.json")
+	exists, err := jsonnetfile.Exists(existing)
 	kingpin.FatalIfError(err, "Failed to check for jsonnetfile.json")
 
 	if exists {
-		kingpin.Errorf("jsonnetfile.json already exists")
+		kingpin.Errorf("%s already exists", filepath.Base(existing))
 		return 1
 	}
 
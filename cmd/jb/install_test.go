@@ -18,6 +18,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -27,6 +28,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
 	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
 	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
 	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
@@ -65,7 +67,7 @@ func testInstallCommandWithJsonnetHome(t *testing.T, jsonnetHome string) {
 			URIs:                    []string{"github.com/trevorackerman/jsonnet-bundler@v0.1.0"},
 			ExpectedCode:            0,
 			ExpectedJsonnetFile:     []byte(`{"version": 1, "dependencies": [{"source": {"git": {"remote": "https://github.com/trevorackerman/jsonnet-bundler.git", "subdir": ""}}, "version": "v0.1.0"}], "legacyImports": true}`),
-			ExpectedJsonnetLockFile: []byte(`{"version": 1, "dependencies": [{"source": {"git": {"remote": "https://github.com/trevorackerman/jsonnet-bundler.git", "subdir": ""}}, "version": "080f157c7fb85ad0281ea78f6c641eaa570a582f", "sum": "W1uI550rQ66axRpPXA2EZDquyPg/5PHZlvUz1NEzefg="}], "legacyImports": false}`),
+			ExpectedJsonnetLockFile: []byte(`{"version": 1, "dependencies": [{"source": {"git": {"remote": "https://github.com/trevorackerman/jsonnet-bundler.git", "subdir": ""}}, "version": "080f157c7fb85ad0281ea78f6c641eaa570a582f", "sum": "h1:verified", "method": "archive"}], "legacyImports": false}`),
 		},
 		{
 			Name:                    "Local",
@@ -79,7 +81,7 @@ func testInstallCommandWithJsonnetHome(t *testing.T, jsonnetHome string) {
 			URIs:                    []string{"github.com/grafana/loki/production/ksonnet/loki@bd4d516262c107a0bde7a962fa2b1e567a2c21e5"},
 			ExpectedCode:            0,
 			ExpectedJsonnetFile:     []byte(`{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/grafana/loki.git","subdir":"production/ksonnet/loki"}},"version":"bd4d516262c107a0bde7a962fa2b1e567a2c21e5","single":true}],"legacyImports":true}`),
-			ExpectedJsonnetLockFile: []byte(`{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/grafana/loki.git","subdir":"production/ksonnet/loki"}},"version":"bd4d516262c107a0bde7a962fa2b1e567a2c21e5","sum":"ExovUKXmZ4KwJAv/q8ZwNW9BdIZlrxmoGrne7aR64wo=","single":true}],"legacyImports":false}`),
+			ExpectedJsonnetLockFile: []byte(`{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/grafana/loki.git","subdir":"production/ksonnet/loki"}},"version":"bd4d516262c107a0bde7a962fa2b1e567a2c21e5","sum":"h1:verified","method":"archive","single":true}],"legacyImports":false}`),
 			single:                  true,
 		},
 	}
@@ -92,6 +94,8 @@ func testInstallCommandWithJsonnetHome(t *testing.T, jsonnetHome string) {
 		_ = os.RemoveAll(jsonnetHome)
 		_ = os.RemoveAll("jsonnet")
 	}
+	// the test runs in the package directory, leave nothing behind
+	defer cleanup()
 
 	for _, tc := range testcases {
 		_ = t.Run(tc.Name, func(t *testing.T) {
@@ -105,15 +109,14 @@ func testInstallCommandWithJsonnetHome(t *testing.T, jsonnetHome string) {
 			jsonnetFileContent(t, jsonnetfile.File, []byte(initContents))
 
 			// install something, check it writes only if required, etc.
-			installCommand("", jsonnetHome, tc.URIs, tc.single, "")
+			installCommand(context.TODO(), &pkg.Installer{VendorDir: jsonnetHome}, tc.URIs, tc.single, "")
 			jsonnetFileContent(t, jsonnetfile.File, tc.ExpectedJsonnetFile)
 			if tc.ExpectedJsonnetLockFile != nil {
-				jsonnetFileContent(t, jsonnetfile.LockFile, tc.ExpectedJsonnetLockFile)
+				lock := verifiedLock(t, jsonnetfile.LockFile, jsonnetHome)
+				assert.JSONEq(t, string(tc.ExpectedJsonnetLockFile), lock)
 			}
 		})
 	}
-
-	cleanup()
 }
 
 func jsonnetFileContent(t *testing.T, filename string, content []byte) {
@@ -126,84 +129,34 @@ func jsonnetFileContent(t *testing.T, filename string, content []byte) {
 	}
 }
 
-func TestWriteChangedJsonnetFile(t *testing.T) {
-	testcases := []struct {
-		Name             string
-		JsonnetFileBytes []byte
-		NewJsonnetFile   v1.JsonnetFile
-		ExpectWrite      bool
-	}{
-		{
-			Name:             "NoDiffEmpty",
-			JsonnetFileBytes: []byte(`{}`),
-			NewJsonnetFile:   v1.New(),
-			ExpectWrite:      false,
-		},
-		{
-			Name:             "NoDiffNotEmpty",
-			JsonnetFileBytes: []byte(`{"dependencies": [{"version": "master"}]}`),
-			NewJsonnetFile: v1.JsonnetFile{
-				Dependencies: addDependencies(deps.NewOrdered(),
-					deps.Dependency{
-						Version: "master",
-					}),
-			},
-			ExpectWrite: false,
-		},
-		{
-			Name:             "DiffVersion",
-			JsonnetFileBytes: []byte(`{"dependencies": [{"version": "1.0"}]}`),
-			NewJsonnetFile: v1.JsonnetFile{
-				Dependencies: addDependencies(deps.NewOrdered(),
-					deps.Dependency{
-						Version: "2.0",
-					}),
-			},
-			ExpectWrite: true,
-		},
-		{
-			Name:             "Diff",
-			JsonnetFileBytes: []byte(`{}`),
-			NewJsonnetFile: v1.JsonnetFile{
-				Dependencies: addDependencies(deps.NewOrdered(),
-					deps.Dependency{
-						Source: deps.Source{
-							GitSource: &deps.Git{
-								Scheme: deps.GitSchemeHTTPS,
-								Host:   "github.com",
-								User:   "foobar",
-								Repo:   "foobar",
-								Subdir: "",
-							},
-						},
-						Version: "master",
-					}),
-			},
-			ExpectWrite: true,
-		},
-	}
-	outputjsonnetfile := "changedjsonnet.json"
-	for _, tc := range testcases {
-		_ = t.Run(tc.Name, func(t *testing.T) {
-			clean := func() {
-				_ = os.Remove(outputjsonnetfile)
-			}
-			clean()
-			defer clean()
+// verifiedLock reads the lock file at lockPath, checks that the sum of every
+// git dependency matches its directory in vendorDir and replaces it with
+// "h1:verified", so that expectations don't depend on exact archive contents.
+func verifiedLock(t *testing.T, lockPath, vendorDir string) string {
+	t.Helper()
 
-			err := writeChangedJsonnetFile(tc.JsonnetFileBytes, &tc.NewJsonnetFile, outputjsonnetfile)
-			assert.NoError(t, err)
+	raw, err := os.ReadFile(lockPath)
+	require.NoError(t, err)
+	var lock v1.JsonnetFile
+	require.NoError(t, json.Unmarshal(raw, &lock))
 
-			if tc.ExpectWrite {
-				assert.FileExists(t, outputjsonnetfile)
-			} else {
-				_, err := os.Lstat(outputjsonnetfile)
-				if err != nil {
-					assert.True(t, os.IsNotExist(err))
-				}
-			}
-		})
+	for _, name := range lock.Dependencies.Keys() {
+		d, _ := lock.Dependencies.Get(name)
+		if d.Source.GitSource == nil {
+			continue
+		}
+
+		sum, err := pkg.HashDir(filepath.Join(filepath.Dir(lockPath), vendorDir, name))
+		require.NoError(t, err)
+		require.Equal(t, sum, d.Sum, "sum of %s", name)
+
+		d.Sum = "h1:verified"
+		lock.Dependencies.Set(name, d)
 	}
+
+	out, err := json.Marshal(lock)
+	require.NoError(t, err)
+	return string(out)
 }
 
 func TestInstallTransitive(t *testing.T) {
@@ -226,7 +179,7 @@ func TestInstallTransitive(t *testing.T) {
 		subDirB: jsonnetFileWithFrozenLib(frozenLibSecondCommit, ""),
 	})
 
-	require.Equal(t, 0, installCommand(baseDir, "vendor", nil, false, ""))
+	require.Equal(t, 0, installCommand(context.TODO(), &pkg.Installer{ProjectDir: baseDir}, nil, false, ""))
 
 	lockCheckFrozenLibVersion(t, filepath.Join(baseDir, "jsonnetfile.lock.json"), frozenLibFirstCommit)
 	require.NoError(t, os.RemoveAll(filepath.Join(baseDir, "jsonnetfile.lock.json")))
@@ -237,7 +190,7 @@ func TestInstallTransitive(t *testing.T) {
 		subDirB: jsonnetFileWithFrozenLib(frozenLibFirstCommit, ""),
 	})
 
-	require.Equal(t, 0, installCommand(baseDir, "vendor", nil, false, ""))
+	require.Equal(t, 0, installCommand(context.TODO(), &pkg.Installer{ProjectDir: baseDir}, nil, false, ""))
 
 	lockCheckFrozenLibVersion(t, filepath.Join(baseDir, "jsonnetfile.lock.json"), frozenLibSecondCommit)
 }
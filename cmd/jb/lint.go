@@ -0,0 +1,64 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/tool/lint"
+)
+
+func lintCommand(dir, vendorDir, lockFile string, jpaths []string, skipVendor bool) int {
+	locks, err := jsonnetfile.Load(lockFile)
+	if err != nil {
+		kingpin.Fatalf("Failed to load lockFile: %s.\nThe vendored packages are required to resolve imports. Make sure to run `jb install` first.", err)
+	}
+
+	abs := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	for i := range jpaths {
+		jpaths[i] = abs(jpaths[i])
+	}
+
+	problems, err := lint.Lint(lint.Project{
+		Dir:        dir,
+		VendorDir:  abs(vendorDir),
+		Locks:      locks.Dependencies,
+		JPaths:     jpaths,
+		SkipVendor: skipVendor,
+	})
+	kingpin.FatalIfError(err, "linting imports")
+
+	for _, p := range problems {
+		if rel, err := filepath.Rel(dir, p.File); err == nil {
+			p.File = rel
+		}
+		fmt.Fprintln(os.Stdout, p)
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -15,22 +15,34 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/trevorackerman/jsonnet-bundler/pkg"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
 )
 
 const (
-	installActionName = "install"
-	updateActionName  = "update"
-	initActionName    = "init"
-	rewriteActionName = "rewrite"
+	installActionName  = "install"
+	updateActionName   = "update"
+	initActionName     = "init"
+	rewriteActionName  = "rewrite"
+	sumActionName      = "sum"
+	tidyActionName     = "tidy"
+	lintActionName     = "lint"
+	vendorActionName   = "vendor"
+	mvActionName       = "mv"
+	validateActionName = "validate"
 )
 
 var Version = "dev"
@@ -39,20 +51,54 @@ func main() {
 	os.Exit(Main())
 }
 
+// redactErrors hides credentials in the errors printed by kingpin, as they may
+// wrap URLs or the output of git
+func redactErrors(w io.Writer) {
+	kingpin.CommandLine.ErrorWriter(pkg.RedactWriter(w))
+}
+
 func Main() int {
 	cfg := struct {
-		JsonnetHome string
+		JsonnetHome    string
+		Timeout        time.Duration
+		RequestTimeout time.Duration
+		ConfigFile     string
+		Output         string
+		Quiet          bool
+		Verbose        bool
+		Debug          bool
+		NoWait         bool
 	}{}
+	retry := retryFlags{}
+	network := httpFlags{}
 
 	color.Output = color.Error
+	jsonnetfile.JBVersion = Version
+	redactErrors(os.Stderr)
 
 	a := kingpin.New(filepath.Base(os.Args[0]), "A jsonnet package manager").Version(Version)
 	a.HelpFlag.Short('h')
 
 	a.Flag("jsonnetpkg-home", "The directory used to cache packages in.").
 		Default("vendor").StringVar(&cfg.JsonnetHome)
-	a.Flag("quiet", "Suppress any output from git command.").
-		Short('q').BoolVar(&pkg.GitQuiet)
+	a.Flag("quiet", "Only print errors.").
+		Short('q').BoolVar(&cfg.Quiet)
+	a.Flag("verbose", "Print every step, including the output of git commands.").
+		Short('v').BoolVar(&cfg.Verbose)
+	a.Flag("debug", "Print every file being processed. Implies --verbose.").
+		BoolVar(&cfg.Debug)
+	a.Flag("timeout", "Abort the command if it takes longer than this (0 disables the limit).").
+		Default("0s").DurationVar(&cfg.Timeout)
+	a.Flag("request-timeout", "Abort a single download or git command if it takes longer than this (0 disables the limit).").
+		Default("0s").DurationVar(&cfg.RequestTimeout)
+	retry.register(a)
+	network.register(a)
+	a.Flag("no-wait", "Fail instead of waiting if another jb process is modifying the same project.").
+		BoolVar(&cfg.NoWait)
+	a.Flag("output", "Format of the progress output: text for humans on stderr, jsonl for tools on stdout.").
+		Short('o').Default(outputText).EnumVar(&cfg.Output, outputFormats...)
+	a.Flag("config", "The jb configuration file. Defaults to jb/config.json in the user's config directory.").
+		Envar("JB_CONFIG").StringVar(&cfg.ConfigFile)
 
 	initCmd := a.Command(initActionName, "Initialize a new empty jsonnetfile")
 
@@ -65,10 +111,36 @@ func Main() int {
 	updateCmdURIs := updateCmd.Arg("uris", "URIs to packages to update, URLs or file paths").Strings()
 
 	rewriteCmd := a.Command(rewriteActionName, "Automatically rewrite legacy imports to absolute ones")
+	rewriteCmdCheck := rewriteCmd.Flag("check", "Print the changes as a unified diff instead, failing if there are any").Bool()
+	rewriteCmdDryRun := rewriteCmd.Flag("dry-run", "Only list the files that would be rewritten").Bool()
+
+	sumCmd := a.Command(sumActionName, "Print the checksum of a directory, as recorded in jsonnetfile.lock.json")
+	sumCmdDir := sumCmd.Arg("dir", "Directory to checksum").Required().ExistingDir()
+
+	tidyCmd := a.Command(tidyActionName, "Remove dependencies that are never imported and declare the ones imported through other packages")
+	tidyCmdYes := tidyCmd.Flag("yes", "Apply the changes without asking, e.g. in CI").Short('y').Bool()
+	tidyCmdDryRun := tidyCmd.Flag("dry-run", "Only list the changes").Bool()
+
+	lintCmd := a.Command(lintActionName, "Check that all imports resolve to packages declared by the importing module")
+	lintCmdJPaths := lintCmd.Flag("jpath", "Additional library search directory of the project, as passed to jsonnet").Short('J').Strings()
+	lintCmdSkipVendor := lintCmd.Flag("skip-vendor", "Only check the files of the project, not the vendored ones").Bool()
+
+	vendorCmd := a.Command(vendorActionName, "Install the complete locked packages, or only the files the project can reach")
+	vendorCmdPrune := vendorCmd.Flag("prune-unreachable", "Remove all vendored files no entrypoint imports, directly or transitively").Bool()
+	vendorCmdEntrypoints := vendorCmd.Flag("entrypoint", "File evaluated by jsonnet, or directory of such files. Defaults to all Jsonnet files of the project").Short('e').Strings()
+	vendorCmdJPaths := vendorCmd.Flag("jpath", "Additional library search directory of the project, as passed to jsonnet").Short('J').Strings()
+	vendorCmdDryRun := vendorCmd.Flag("dry-run", "Only list the files that would be removed").Bool()
+
+	mvCmd := a.Command(mvActionName, "Replace a dependency with the package at a new location and rewrite its imports")
+	mvCmdFrom := mvCmd.Arg("old-name", "Name or URI of the dependency to replace").Required().String()
+	mvCmdTo := mvCmd.Arg("new-uri", "URI of the new location. Keeps the current version unless one is given").Required().String()
+
+	validateCmd := a.Command(validateActionName, "Check jsonnetfile.json and jsonnetfile.lock.json for unknown keys and other mistakes")
+	validateCmdSchema := validateCmd.Flag("print-schema", "Print the JSON Schema of jsonnetfiles instead, for use in editors").Bool()
 
 	command, err := a.Parse(os.Args[1:])
 	if err != nil {
-		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Error parsing commandline arguments"))
+		fmt.Fprintln(os.Stderr, pkg.Redact(errors.Wrapf(err, "Error parsing commandline arguments").Error()))
 		a.Usage(os.Args[1:])
 		return 2
 	}
@@ -80,18 +152,112 @@ func Main() int {
 
 	cfg.JsonnetHome = filepath.Clean(cfg.JsonnetHome)
 
+	conf, err := loadConfig(cfg.ConfigFile)
+	if err != nil {
+		kingpin.Errorf("failed to load configuration: %s", err)
+		return 1
+	}
+	policy := retry.policy(conf.Retry)
+
+	rules, err := rewrites(conf.Rewrite)
+	if err != nil {
+		kingpin.Errorf("failed to load configuration: %s", err)
+		return 1
+	}
+
+	settings := network.settings(conf.HTTP)
+	client, err := settings.Client()
+	if err != nil {
+		kingpin.Errorf("invalid network settings: %s", err)
+		return 1
+	}
+
+	inst := &pkg.Installer{
+		ProjectDir:     workdir,
+		VendorDir:      cfg.JsonnetHome,
+		HTTPClient:     client,
+		Git:            settings.Git(),
+		Retry:          &policy,
+		RequestTimeout: cfg.RequestTimeout,
+		Credentials:    pkg.DefaultCredentials(),
+		Rewrites:       rules,
+		NoWait:         cfg.NoWait,
+	}
+
+	// members of a workspace share the lock and vendor directory of it
+	ws, err := pkg.FindWorkspace(workdir)
+	if err != nil {
+		kingpin.Errorf("failed to load workspace: %s", err)
+		return 1
+	}
+	vendorDir, lockFile := cfg.JsonnetHome, inst.LockPath()
+	var members []string
+	if ws != nil {
+		inst.WorkspaceDir = ws.Dir
+		vendorDir, lockFile, members = inst.VendorPath(), inst.LockPath(), ws.Members
+	}
+
+	// cancel all running downloads and git commands on Ctrl-C or when the
+	// process is asked to terminate, so partial state can be cleaned up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	level := logLevel(cfg.Quiet, cfg.Verbose, cfg.Debug)
+	ctx = pkg.WithLogger(ctx, pkg.NewLogger(color.Error, level))
+
+	sum := &summary{}
+	ctx = pkg.WithReporter(ctx, pkg.MultiReporter{newReporter(cfg.Output, level, os.Stdout), sum})
+	defer func() {
+		if cfg.Output == outputText && level <= pkg.LevelWarn && !sum.empty() {
+			fmt.Fprintln(color.Error, sum)
+		}
+	}()
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
 	switch command {
 	case initCmd.FullCommand():
 		return initCommand(workdir)
 	case installCmd.FullCommand():
-		return installCommand(workdir, cfg.JsonnetHome, *installCmdURIs, *installCmdSingle, *installCmdLegacyName)
+		return installCommand(ctx, inst, *installCmdURIs, *installCmdSingle, *installCmdLegacyName)
 	case updateCmd.FullCommand():
-		return updateCommand(workdir, cfg.JsonnetHome, *updateCmdURIs)
+		return updateCommand(ctx, inst, *updateCmdURIs)
 	case rewriteCmd.FullCommand():
-		return rewriteCommand(workdir, cfg.JsonnetHome)
+		return rewriteCommand(workdir, vendorDir, lockFile, *rewriteCmdCheck, *rewriteCmdDryRun)
+	case sumCmd.FullCommand():
+		return sumCommand(*sumCmdDir)
+	case lintCmd.FullCommand():
+		return lintCommand(workdir, vendorDir, lockFile, *lintCmdJPaths, *lintCmdSkipVendor)
+	case tidyCmd.FullCommand():
+		return tidyCommand(ctx, inst, workdir, vendorDir, lockFile, *tidyCmdYes, *tidyCmdDryRun)
+	case vendorCmd.FullCommand():
+		return vendorCommand(ctx, inst, workdir, vendorDir, lockFile, members, *vendorCmdPrune, *vendorCmdEntrypoints, *vendorCmdJPaths, *vendorCmdDryRun)
+	case mvCmd.FullCommand():
+		return mvCommand(ctx, inst, workdir, vendorDir, lockFile, members, *mvCmdFrom, *mvCmdTo)
+	case validateCmd.FullCommand():
+		return validateCommand(workdir, vendorDir, lockFile, members, *validateCmdSchema)
 	default:
-		installCommand(workdir, cfg.JsonnetHome, []string{}, false, "")
+		installCommand(ctx, inst, []string{}, false, "")
 	}
 
 	return 0
 }
+
+// logLevel maps the verbosity flags to a pkg.Level. By default, only warnings
+// and a summary are printed.
+func logLevel(quiet, verbose, debug bool) pkg.Level {
+	switch {
+	case debug:
+		return pkg.LevelDebug
+	case verbose:
+		return pkg.LevelInfo
+	case quiet:
+		return pkg.LevelError
+	default:
+		return pkg.LevelWarn
+	}
+}
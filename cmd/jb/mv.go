@@ -0,0 +1,142 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	"github.com/trevorackerman/jsonnet-bundler/tool/rewrite"
+)
+
+func mvCommand(ctx context.Context, inst *pkg.Installer, dir, vendorDir, lockFile string, members []string, from, uri string) int {
+	to := deps.Parse(dir, uri)
+	if to == nil {
+		kingpin.Fatalf("Unable to parse package URI `%s`", uri)
+	}
+	// without an explicit version, the current one is kept
+	if !strings.HasSuffix(uri, "@"+to.Version) {
+		to.Version = ""
+	}
+
+	// imports are resolved against the packages as they were before
+	locks, err := jsonnetfile.Load(lockFile)
+	if err != nil && !os.IsNotExist(err) {
+		kingpin.FatalIfError(err, "failed to load lockfile")
+	}
+
+	// kept to undo the move if the imports cannot be rewritten
+	jbfile, err := jsonnetfile.Find(dir)
+	kingpin.FatalIfError(err, "")
+	before, err := snapshotOf(jbfile, lockFile)
+	kingpin.FatalIfError(err, "")
+
+	res, err := inst.Move(ctx, from, *to)
+	kingpin.FatalIfError(err, "")
+
+	// all members of a workspace share the vendored packages
+	roots := members
+	if len(roots) == 0 {
+		roots = []string{dir}
+	}
+	changes, err := rewriteAll(roots, vendorDir, rewrite.Move(res.From, res.To, locks.Dependencies))
+	if err != nil {
+		if undoErr := undoMove(ctx, inst, before, changes); undoErr != nil {
+			kingpin.Fatalf("rewriting imports: %s, undoing the move: %s", err, undoErr)
+		}
+		kingpin.FatalIfError(err, "rewriting imports, the move was undone")
+	}
+
+	for _, c := range changes {
+		if rel, err := filepath.Rel(dir, c.File); err == nil {
+			c.File = rel
+		}
+		fmt.Fprintln(os.Stdout, c.File)
+	}
+	return 0
+}
+
+// rewriteAll rewrites the imports of the Jsonnet files below any of roots,
+// each file once. The changes computed so far are returned along with an
+// error, as some of them may have been written already.
+func rewriteAll(roots []string, vendorDir string, rename rewrite.Renamer) ([]rewrite.Change, error) {
+	var changes []rewrite.Change
+	seen := map[string]bool{}
+	for _, root := range roots {
+		cs, err := rewrite.Changes(root, vendorDir, rename)
+		if err != nil {
+			return changes, err
+		}
+		// members may be nested
+		for _, c := range cs {
+			if !seen[c.File] {
+				seen[c.File] = true
+				changes = append(changes, c)
+			}
+		}
+	}
+	return changes, rewrite.Write(changes)
+}
+
+// snapshot holds the contents of files, nil for the ones that do not exist
+type snapshot map[string][]byte
+
+func snapshotOf(files ...string) (snapshot, error) {
+	s := snapshot{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		s[f] = data
+	}
+	return s, nil
+}
+
+// undoMove restores the jsonnetfile and lock of before and the files changed
+// so far, then vendors the packages of the restored lock again
+func undoMove(ctx context.Context, inst *pkg.Installer, before snapshot, changes []rewrite.Change) error {
+	reverted := make([]rewrite.Change, len(changes))
+	for i, c := range changes {
+		reverted[i] = rewrite.Change{File: c.File, Before: c.After, After: c.Before}
+	}
+	if err := rewrite.Write(reverted); err != nil {
+		return err
+	}
+
+	for f, data := range before {
+		if data == nil {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(f, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	_, err := inst.Install(ctx, nil, pkg.InstallOptions{})
+	return err
+}
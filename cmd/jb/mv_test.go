@@ -0,0 +1,75 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+)
+
+func TestRewriteAll(t *testing.T) {
+	rename := func(p string) (string, bool) {
+		if !strings.HasPrefix(p, "old/") {
+			return "", false
+		}
+		return "new/" + strings.TrimPrefix(p, "old/"), true
+	}
+	read := func(t *testing.T, f string) string {
+		data, err := ioutil.ReadFile(f)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("Members", func(t *testing.T) {
+		dir := t.TempDir()
+		testutil.WriteFiles(t, dir, map[string]string{
+			"a/main.jsonnet":         "import 'old/x.libsonnet'",
+			"a/nested/main.jsonnet":  "import 'old/y.libsonnet'",
+			"b/main.jsonnet":         "import 'old/z.libsonnet'",
+			"vendor/old/x.libsonnet": "import 'old/z.libsonnet'",
+		})
+		roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "a/nested"), filepath.Join(dir, "b")}
+
+		changes, err := rewriteAll(roots, filepath.Join(dir, "vendor"), rename)
+		require.NoError(t, err)
+		assert.Len(t, changes, 3)
+
+		assert.Equal(t, "import 'new/x.libsonnet'", read(t, filepath.Join(dir, "a/main.jsonnet")))
+		assert.Equal(t, "import 'new/y.libsonnet'", read(t, filepath.Join(dir, "a/nested/main.jsonnet")))
+		assert.Equal(t, "import 'new/z.libsonnet'", read(t, filepath.Join(dir, "b/main.jsonnet")))
+		assert.Equal(t, "import 'old/z.libsonnet'", read(t, filepath.Join(dir, "vendor/old/x.libsonnet")))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		dir := t.TempDir()
+		testutil.WriteFiles(t, dir, map[string]string{
+			"a/main.jsonnet": "import 'old/x.libsonnet'",
+			"b/main.jsonnet": "import 'old/z.libsonnet",
+		})
+		roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+
+		changes, err := rewriteAll(roots, filepath.Join(dir, "vendor"), rename)
+		assert.Error(t, err)
+		assert.Len(t, changes, 1)
+		assert.Equal(t, "import 'old/x.libsonnet'", read(t, filepath.Join(dir, "a/main.jsonnet")))
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fatih/color"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+)
+
+const (
+	outputText  = "text"
+	outputJSONL = "jsonl"
+)
+
+var outputFormats = []string{outputText, outputJSONL}
+
+// newReporter returns a pkg.Reporter rendering events in the given format:
+// text is progress for humans on stderr, jsonl writes one json object per
+// event to w for tools.
+func newReporter(format string, level pkg.Level, w io.Writer) pkg.Reporter {
+	switch format {
+	case outputJSONL:
+		return &jsonlReporter{enc: json.NewEncoder(w)}
+	default:
+		return textReporter{level: level}
+	}
+}
+
+type jsonlReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (r *jsonlReporter) Report(e pkg.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// nothing sensible to do about a broken pipe here
+	_ = r.enc.Encode(e)
+}
+
+// textReporter prints every step at pkg.LevelInfo, only problems otherwise
+type textReporter struct {
+	level pkg.Level
+}
+
+func (r textReporter) Report(e pkg.Event) {
+	if r.level <= pkg.LevelWarn {
+		switch e.Type {
+		case pkg.EventDownloadFinish:
+			if e.Error != "" {
+				color.Red("FAIL %s@%s: %s", e.Package, e.Version, e.Error)
+			}
+		case pkg.EventRetry:
+			color.Yellow("RETRY %s", e.Message)
+		case pkg.EventWarning:
+			color.Yellow("WARN: %s", e.Message)
+		}
+	}
+
+	if r.level > pkg.LevelInfo {
+		return
+	}
+
+	switch e.Type {
+	case pkg.EventDownloadStart:
+		color.Cyan("GET %s@%s", e.Package, e.Version)
+	case pkg.EventChecksum:
+		if e.OK != nil && !*e.OK {
+			color.Yellow("CHANGED %s: vendored files do not match the lock", e.Package)
+		}
+	case pkg.EventClean:
+		color.Magenta("CLEAN %s", e.Path)
+	case pkg.EventLink:
+		color.Magenta("LINK %s -> %s", e.Path, e.Target)
+	}
+}
+
+// summary counts the events of an installation, so a single line can be
+// printed at the end
+type summary struct {
+	mu         sync.Mutex
+	packages   map[string]bool
+	downloaded int
+	cleaned    int
+}
+
+func (s *summary) Report(e pkg.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch e.Type {
+	case pkg.EventResolve:
+		if s.packages == nil {
+			s.packages = make(map[string]bool)
+		}
+		s.packages[e.Package] = true
+	case pkg.EventDownloadFinish:
+		if e.Error == "" {
+			s.downloaded++
+		}
+	case pkg.EventClean:
+		s.cleaned++
+	}
+}
+
+// empty returns whether nothing was installed or removed
+func (s *summary) empty() bool {
+	return len(s.packages) == 0 && s.cleaned == 0
+}
+
+func (s *summary) String() string {
+	noun := "packages"
+	if len(s.packages) == 1 {
+		noun = "package"
+	}
+	return fmt.Sprintf("%d %s up to date (%d downloaded, %d removed)", len(s.packages), noun, s.downloaded, s.cleaned)
+}
@@ -14,6 +14,8 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -22,14 +24,37 @@ import (
 	"github.com/trevorackerman/jsonnet-bundler/tool/rewrite"
 )
 
-func rewriteCommand(dir, vendorDir string) int {
-	locks, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+func rewriteCommand(dir, vendorDir, lockFile string, check, dryRun bool) int {
+	locks, err := jsonnetfile.Load(lockFile)
 	if err != nil {
 		kingpin.Fatalf("Failed to load lockFile: %s.\nThe locks are required to compute the new import names. Make sure to run `jb install` first.", err)
 	}
 
-	if err := rewrite.Rewrite(dir, vendorDir, locks.Dependencies); err != nil {
-		kingpin.FatalIfError(err, "")
+	changes, err := rewrite.Changes(dir, vendorDir, rewrite.Legacy(locks.Dependencies))
+	kingpin.FatalIfError(err, "")
+
+	// show paths relative to the project
+	display := func(c rewrite.Change) rewrite.Change {
+		if rel, err := filepath.Rel(dir, c.File); err == nil {
+			c.File = rel
+		}
+		return c
+	}
+
+	switch {
+	case check:
+		for _, c := range changes {
+			fmt.Fprint(os.Stdout, display(c).Diff())
+		}
+		if len(changes) > 0 {
+			return 1
+		}
+	case dryRun:
+		for _, c := range changes {
+			fmt.Fprintln(os.Stdout, display(c).File)
+		}
+	default:
+		kingpin.FatalIfError(rewrite.Write(changes), "")
 	}
 
 	return 0
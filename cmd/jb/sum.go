@@ -0,0 +1,31 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+)
+
+func sumCommand(dir string) int {
+	sum, err := pkg.HashDir(dir)
+	kingpin.FatalIfError(err, "computing checksum of %s", dir)
+
+	fmt.Println(sum)
+	return 0
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	"github.com/trevorackerman/jsonnet-bundler/tool/tidy"
+)
+
+func tidyCommand(ctx context.Context, inst *pkg.Installer, dir, vendorDir, lockFile string, yes, dryRun bool) int {
+	jbfile, err := jsonnetfile.Find(dir)
+	kingpin.FatalIfError(err, "failed to load jsonnetfile")
+	jf, err := jsonnetfile.Load(jbfile)
+	kingpin.FatalIfError(err, "failed to load jsonnetfile")
+
+	locks, err := jsonnetfile.Load(lockFile)
+	if err != nil {
+		kingpin.Fatalf("Failed to load lockFile: %s.\nThe vendored packages are required to resolve imports. Make sure to run `jb install` first.", err)
+	}
+
+	if !filepath.IsAbs(vendorDir) {
+		vendorDir = filepath.Join(dir, vendorDir)
+	}
+
+	res, err := tidy.Analyze(dir, vendorDir, jf.Dependencies, locks.Dependencies)
+	kingpin.FatalIfError(err, "analyzing imports")
+
+	if len(res.Unused) == 0 && len(res.Transitive) == 0 {
+		return 0
+	}
+	printTidy(os.Stdout, dir, res)
+
+	switch {
+	case dryRun:
+		return 0
+	case yes:
+	case !isatty.IsTerminal(os.Stdin.Fd()):
+		// nobody to ask
+		fmt.Fprintln(os.Stderr, "Not applying the changes without confirmation, as stdin is not a terminal. Use --yes to apply them.")
+		return 1
+	case !confirm(os.Stdin, os.Stderr, "Apply these changes?"):
+		return 1
+	}
+
+	if len(res.Unused) > 0 {
+		names := make([]string, 0, len(res.Unused))
+		for _, d := range res.Unused {
+			names = append(names, d.Name())
+		}
+		_, err := inst.Remove(ctx, names)
+		kingpin.FatalIfError(err, "removing unused dependencies")
+	}
+
+	if len(res.Transitive) > 0 {
+		add := make([]deps.Dependency, 0, len(res.Transitive))
+		for _, t := range res.Transitive {
+			// declare the version that is in use already
+			d := t.Dependency
+			d.Sum, d.Method, d.Pruned = "", "", nil
+			add = append(add, d)
+		}
+		_, err := inst.InstallDependencies(ctx, add, pkg.InstallOptions{})
+		kingpin.FatalIfError(err, "adding imported dependencies")
+	}
+
+	return 0
+}
+
+func printTidy(w io.Writer, dir string, res *tidy.Result) {
+	for _, d := range res.Unused {
+		fmt.Fprintf(w, "unused: %s is never imported\n", d.Name())
+	}
+
+	for _, t := range res.Transitive {
+		via := ""
+		if len(t.RequiredBy) > 0 {
+			via = fmt.Sprintf(", only required by %s", strings.Join(t.RequiredBy, ", "))
+		}
+		fmt.Fprintf(w, "missing: %s is imported but not declared%s\n", t.Dependency.Name(), via)
+
+		for _, i := range t.Imports {
+			file, err := filepath.Rel(dir, i.File)
+			if err != nil {
+				file = i.File
+			}
+			fmt.Fprintf(w, "\t%s:%d: %s %q\n", file, i.Line, i.Kind, i.Path)
+		}
+	}
+}
+
+// confirm asks a yes/no question if in is a terminal. Anything but yes is
+// taken as no.
+func confirm(in *os.File, out io.Writer, question string) bool {
+	if !isatty.IsTerminal(in.Fd()) {
+		return false
+	}
+
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -15,55 +15,16 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
+	"context"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/trevorackerman/jsonnet-bundler/pkg"
-	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
-	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
-	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
 )
 
-func updateCommand(dir, jsonnetHome string, uris []string) int {
-	if dir == "" {
-		dir = "."
-	}
-
-	// load jsonnetfiles
-	jsonnetFile, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
-	kingpin.FatalIfError(err, "failed to load jsonnetfile")
-
-	lockFile, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
-	kingpin.FatalIfError(err, "failed to load lockfile")
-
-	kingpin.FatalIfError(
-		os.MkdirAll(filepath.Join(dir, jsonnetHome, ".tmp"), os.ModePerm),
-		"creating vendor folder")
-
-	locks := lockFile.Dependencies
-
-	for _, u := range uris {
-		d := deps.Parse(dir, u)
-		if d == nil {
-			kingpin.Fatalf("Unable to parse package URI `%s`", u)
-		}
-
-		locks.Delete(d.Name())
-	}
-
-	// no uris: update all
-	if len(uris) == 0 {
-		locks = deps.NewOrdered()
-	}
-
-	newLocks, err := pkg.Ensure(jsonnetFile, filepath.Join(dir, jsonnetHome), locks)
-	kingpin.FatalIfError(err, "updating")
-
-	kingpin.FatalIfError(
-		writeJSONFile(filepath.Join(dir, jsonnetfile.LockFile), v1.JsonnetFile{Dependencies: newLocks}),
-		"updating jsonnetfile.lock.json")
+func updateCommand(ctx context.Context, inst *pkg.Installer, uris []string) int {
+	_, err := inst.Update(ctx, uris)
+	kingpin.FatalIfError(err, "")
 
 	return 0
 }
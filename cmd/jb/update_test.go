@@ -18,11 +18,13 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
 	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -64,9 +66,8 @@ func (rs RepoState) Assert(t *testing.T, dir string) {
 	require.NoError(t, err)
 	assert.JSONEq(t, rs.File, string(file))
 
-	lock, err := ioutil.ReadFile(rs.LockPath(dir))
-	require.NoError(t, err)
-	assert.JSONEq(t, rs.Lock, string(lock))
+	lock := verifiedLock(t, rs.LockPath(dir), "vendor")
+	assert.JSONEq(t, rs.Lock, lock)
 }
 
 // UpdateCase is a testcase for jb update
@@ -89,7 +90,7 @@ func (u UpdateCase) Run(t *testing.T) {
 		require.NoError(t, err)
 	}
 
-	ret := updateCommand(dir, "vendor", u.uris)
+	ret := updateCommand(context.TODO(), &pkg.Installer{ProjectDir: dir}, u.uris)
 	assert.Equal(t, ret, 0)
 
 	if u.after != nil {
@@ -108,7 +109,7 @@ func TestUpdate(t *testing.T) {
 			},
 			after: &RepoState{
 				File: `{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/jsonnet-bundler/frozen-lib.git","subdir":""}},"version":"master"}],"legacyImports":true}`,
-				Lock: `{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/jsonnet-bundler/frozen-lib.git","subdir":""}},"version":"ed7c1aff9e10d3b42fb130446d495f1c769ecd7b","sum":"OraOcUvDIx9Eikaihi8XsRNRsVehO75Ek35im/jYoSA="}],"legacyImports":false}`,
+				Lock: `{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/jsonnet-bundler/frozen-lib.git","subdir":""}},"version":"ed7c1aff9e10d3b42fb130446d495f1c769ecd7b","sum":"h1:verified","method":"archive"}],"legacyImports":false}`,
 			},
 		},
 		{
@@ -120,7 +121,7 @@ func TestUpdate(t *testing.T) {
 			},
 			after: &RepoState{
 				File: `{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/grafana/jsonnet-libs.git","subdir":"ksonnet-util"}},"version":"master"},{"source":{"git":{"remote":"https://github.com/jsonnet-bundler/frozen-lib.git","subdir":""}},"version":"master"}],"legacyImports":true}`,
-				Lock: `{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/grafana/jsonnet-libs.git","subdir":"ksonnet-util"}},"version":"610b00d219d0a6f3d833dd44e4bb0deda2429da0","sum":"XdIrw3m7I8fJ3CL9eR8LtuYcanf2QK78n4H4OBBOADc="},{"source":{"git":{"remote":"https://github.com/jsonnet-bundler/frozen-lib.git","subdir":""}},"version":"ed7c1aff9e10d3b42fb130446d495f1c769ecd7b","sum":"OraOcUvDIx9Eikaihi8XsRNRsVehO75Ek35im/jYoSA="}],"legacyImports":false}`,
+				Lock: `{"version":1,"dependencies":[{"source":{"git":{"remote":"https://github.com/grafana/jsonnet-libs.git","subdir":"ksonnet-util"}},"version":"610b00d219d0a6f3d833dd44e4bb0deda2429da0","sum":"h1:verified"},{"source":{"git":{"remote":"https://github.com/jsonnet-bundler/frozen-lib.git","subdir":""}},"version":"ed7c1aff9e10d3b42fb130446d495f1c769ecd7b","sum":"h1:verified","method":"archive"}],"legacyImports":false}`,
 			},
 		},
 	}
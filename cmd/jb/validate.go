@@ -0,0 +1,55 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/tool/validate"
+)
+
+func validateCommand(dir, vendorDir, lockFile string, members []string, printSchema bool) int {
+	if printSchema {
+		b, err := json.MarshalIndent(v1.JSONSchema(), "", "  ")
+		kingpin.FatalIfError(err, "encoding schema")
+		fmt.Fprintln(os.Stdout, string(b))
+		return 0
+	}
+
+	if !filepath.IsAbs(vendorDir) {
+		vendorDir = filepath.Join(dir, vendorDir)
+	}
+
+	problems, err := validate.Validate(validate.Project{Dir: dir, VendorDir: vendorDir, LockFile: lockFile, Members: members})
+	kingpin.FatalIfError(err, "validating jsonnetfiles")
+
+	for _, p := range problems {
+		if rel, err := filepath.Rel(dir, p.File); err == nil {
+			p.File = rel
+		}
+		fmt.Fprintln(os.Stdout, p)
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/tool/prune"
+)
+
+func vendorCommand(ctx context.Context, inst *pkg.Installer, dir, vendorDir, lockFile string, members []string, pruneUnreachable bool, entrypoints, jpaths []string, dryRun bool) int {
+	if !pruneUnreachable {
+		// start from complete packages again
+		if !dryRun {
+			_, err := inst.Unprune(ctx)
+			kingpin.FatalIfError(err, "restoring pruned packages")
+		}
+		return 0
+	}
+
+	abs := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	// files of all members of a workspace may import the shared packages
+	if len(entrypoints) == 0 {
+		entrypoints = members
+	}
+	for i := range entrypoints {
+		entrypoints[i] = abs(entrypoints[i])
+	}
+	for i := range jpaths {
+		jpaths[i] = abs(jpaths[i])
+	}
+
+	// files pruned before may be reachable now. Only these packages are
+	// restored, which may make further files reachable. A dry run looks at
+	// vendor/ as it is.
+	var keep map[string][]string
+	for {
+		locks, err := jsonnetfile.Load(lockFile)
+		if err != nil {
+			kingpin.Fatalf("Failed to load lockFile: %s.\nThe vendored packages are required to resolve imports. Make sure to run `jb install` first.", err)
+		}
+
+		walk, err := prune.Walk(prune.Project{
+			Dir:         dir,
+			VendorDir:   abs(vendorDir),
+			Locks:       locks.Dependencies,
+			JPaths:      jpaths,
+			Entrypoints: entrypoints,
+		})
+		kingpin.FatalIfError(err, "finding reachable files")
+		keep = walk.Keep
+
+		var restore []string
+		for _, name := range walk.Unresolved {
+			if d, ok := locks.Dependencies.Get(name); ok && d.Pruned != nil {
+				restore = append(restore, name)
+			}
+		}
+		if dryRun || len(restore) == 0 {
+			break
+		}
+
+		_, err = inst.Unprune(ctx, restore...)
+		kingpin.FatalIfError(err, "restoring pruned packages")
+	}
+
+	res, err := inst.Prune(ctx, keep, pkg.PruneOptions{DryRun: dryRun})
+	kingpin.FatalIfError(err, "pruning vendored packages")
+
+	if dryRun {
+		names := make([]string, 0, len(res.Removed))
+		for name := range res.Removed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, f := range res.Removed[name] {
+				fmt.Fprintln(os.Stdout, filepath.Join(vendorDir, name, filepath.FromSlash(f)))
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil holds helpers shared by the tests of all packages
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// WriteFiles creates the given files below dir, creating directories as
+// needed. Names are slash separated. Values starting with "->" are symlinks
+// to the rest of the value.
+func WriteFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+
+		if strings.HasPrefix(content, "->") {
+			require.NoError(t, os.Symlink(strings.TrimPrefix(content, "->"), p))
+			continue
+		}
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+}
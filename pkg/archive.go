@@ -0,0 +1,331 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveLimits bounds the resources an archive may consume when extracted.
+// Zero values disable the respective limit.
+type ArchiveLimits struct {
+	// MaxSize is the maximum number of bytes of all extracted files
+	MaxSize int64
+	// MaxFiles is the maximum number of extracted files, links and directories
+	MaxFiles int
+	// MaxRatio is the maximum ratio of extracted to compressed bytes
+	MaxRatio int64
+}
+
+// DefaultArchiveLimits are generous enough for any sane Jsonnet package
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxSize:  1 << 30,
+	MaxFiles: 100000,
+	MaxRatio: 200,
+}
+
+// ratioThreshold is the number of extracted bytes below which MaxRatio is not
+// enforced, as tiny archives naturally compress very well
+const ratioThreshold = 1 << 20
+
+// ArchiveError is returned when an archive is rejected as unsafe
+type ArchiveError struct {
+	// Entry is the offending archive entry, if any
+	Entry  string
+	Reason string
+}
+
+func (e *ArchiveError) Error() string {
+	if e.Entry == "" {
+		return "unsafe archive: " + e.Reason
+	}
+	return fmt.Sprintf("unsafe archive entry '%s': %s", e.Entry, e.Reason)
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func gzipUntar(ctx context.Context, dst string, r io.Reader, subDir string, limits ArchiveLimits) error {
+	compressed := &countingReader{r: r}
+	gzr, err := gzip.NewReader(compressed)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	return untar(ctx, dst, gzr, subDir, limits, compressed)
+}
+
+// untar extracts the archive r to dst. Like in git archives, all paths are
+// expected to share a single top-level directory, which is stripped. File
+// modes are normalized to 0644 and 0755, so that archives from GitHub and from
+// a local git repository produce identical trees.
+//
+// Entries escaping dst or the limits are rejected with an *ArchiveError.
+// Symlinks pointing outside of subDir are not created. compressed counts the
+// bytes of the compressed archive and may be nil if r is not compressed.
+func untar(ctx context.Context, dst string, r io.Reader, subDir string, limits ArchiveLimits, compressed *countingReader) error {
+	tr := tar.NewReader(r)
+	root := filepath.Join(dst, subDir)
+
+	var size int64
+	var files int
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			return nil
+
+		case err != nil:
+			return err
+
+		case header == nil:
+			continue
+		}
+
+		// strip the two first components of the path
+		parts := strings.SplitAfterN(header.Name, "/", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		suffix := parts[1]
+
+		// reject absolute paths and .. segments instead of silently
+		// cleaning them, as no legitimate archive contains those
+		if suffix != "" && !isLocal(filepath.FromSlash(suffix)) {
+			return &ArchiveError{Entry: header.Name, Reason: "path escapes the destination"}
+		}
+
+		// reconstruct the target path for the archive entry
+		target := filepath.Join(dst, suffix)
+
+		// if subdir is provided and target is not under it, skip it
+		if !within(root, target) {
+			continue
+		}
+
+		if limits.MaxFiles > 0 && files >= limits.MaxFiles {
+			return &ArchiveError{Reason: fmt.Sprintf("more than %d files", limits.MaxFiles)}
+		}
+		files++
+
+		// never write through a symlink created by an earlier entry
+		if err := checkNoSymlinks(root, filepath.Dir(target)); err != nil {
+			return &ArchiveError{Entry: header.Name, Reason: err.Error()}
+		}
+
+		// check the file type
+		switch header.Typeflag {
+
+		// create directories as needed
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			size += header.Size
+			if limits.MaxSize > 0 && size > limits.MaxSize {
+				return &ArchiveError{Entry: header.Name, Reason: fmt.Sprintf("extracted size exceeds %d bytes", limits.MaxSize)}
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+
+			mode := os.FileMode(0644)
+			if header.Mode&0111 != 0 {
+				mode = 0755
+			}
+
+			err := func() error {
+				logger(ctx).Debugf("extracting %s", target)
+
+				// a duplicate entry replaces the file instead of writing
+				// to where it may point to
+				if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				// copy over contents
+				if _, err := io.Copy(f, tr); err != nil {
+					return err
+				}
+				return nil
+			}()
+
+			if err != nil {
+				return err
+			}
+
+			if limits.MaxRatio > 0 && compressed != nil && size > ratioThreshold && size > limits.MaxRatio*compressed.n {
+				return &ArchiveError{Reason: fmt.Sprintf("compression ratio exceeds %d", limits.MaxRatio)}
+			}
+
+		case tar.TypeSymlink:
+			// a link replacing an entry could redirect the links resolved
+			// through it so far
+			if _, err := os.Lstat(target); err == nil {
+				report(ctx, Event{
+					Type:    EventWarning,
+					Path:    target,
+					Target:  header.Linkname,
+					Message: fmt.Sprintf("skipping symlink '%s' replacing an existing entry", path.Clean(header.Name)),
+				})
+				continue
+			}
+
+			// only links staying inside of the package can be trusted,
+			// including when they pass through links extracted before
+			link := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(link) || !linkWithin(root, filepath.Dir(target), link) {
+				report(ctx, Event{
+					Type:    EventWarning,
+					Path:    target,
+					Target:  header.Linkname,
+					Message: fmt.Sprintf("skipping symlink '%s' pointing outside of the package", path.Clean(header.Name)),
+				})
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// maxLinkDepth limits how many links resolveLink follows, like ELOOP
+const maxLinkDepth = 40
+
+// linkWithin reports whether the relative link in dir resolves to root or
+// below it
+func linkWithin(root, dir, link string) bool {
+	_, _, ok := resolveLink(root, dir, link, 0)
+	return ok
+}
+
+// resolveLink returns where the relative link in dir points to and whether
+// that exists. Unlike comparing the paths as text, it follows the links
+// already extracted, so a/b -> .. makes c -> a/b/.. point to the parent of a.
+// ok is false if the resolution leaves root at any point, or if .. follows a
+// directory that is missing, as it may still be created as a link later on.
+func resolveLink(root, dir, link string, depth int) (resolved string, exists, ok bool) {
+	if depth > maxLinkDepth {
+		return "", false, false
+	}
+
+	cur, exists := dir, true
+	for _, elem := range strings.Split(link, string(filepath.Separator)) {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if !exists {
+				return "", false, false
+			}
+			cur = filepath.Dir(cur)
+		default:
+			cur = filepath.Join(cur, elem)
+			fi, err := os.Lstat(cur)
+			exists = err == nil
+			if exists && fi.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(cur)
+				if err != nil || filepath.IsAbs(target) {
+					return "", false, false
+				}
+				if cur, exists, ok = resolveLink(root, filepath.Dir(cur), filepath.FromSlash(target), depth+1); !ok {
+					return "", false, false
+				}
+			}
+		}
+		if !within(root, cur) {
+			return "", false, false
+		}
+	}
+	return cur, exists, true
+}
+
+// isLocal reports whether path is relative and stays within the directory it
+// is evaluated in, like filepath.IsLocal, which requires Go 1.20
+func isLocal(path string) bool {
+	if path == "" || filepath.IsAbs(path) || filepath.VolumeName(path) != "" || strings.HasPrefix(path, string(filepath.Separator)) {
+		return false
+	}
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// within returns whether the path p is root or below it
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && isLocal(rel)
+}
+
+// checkNoSymlinks returns an error if any existing component of dir below
+// root is a symlink
+func checkNoSymlinks(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." || !isLocal(rel) {
+		return nil
+	}
+
+	p := root
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("parent directory is a symlink")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	link     string
+	mode     int64
+}
+
+// tarball creates a gzipped tar archive of the given entries
+func tarball(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.link,
+			Mode:     e.mode,
+			Size:     int64(len(e.content)),
+		}
+		if h.Typeflag == 0 {
+			h.Typeflag = tar.TypeReg
+		}
+		if h.Mode == 0 {
+			h.Mode = 0664
+		}
+		if h.Typeflag != tar.TypeReg {
+			h.Size = 0
+		}
+		require.NoError(t, tw.WriteHeader(h))
+		_, err := tw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return buf
+}
+
+func TestUntar(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "dst")
+	ar := tarball(t,
+		tarEntry{name: "repo-sha/", typeflag: tar.TypeDir, mode: 0777},
+		tarEntry{name: "repo-sha/lib/main.libsonnet", content: "{}", mode: 0600},
+		tarEntry{name: "repo-sha/lib/run.sh", content: "true", mode: 0775},
+		tarEntry{name: "repo-sha/lib/link.libsonnet", typeflag: tar.TypeSymlink, link: "main.libsonnet"},
+		tarEntry{name: "repo-sha/libfoo/other.libsonnet", content: "{}"},
+	)
+
+	require.NoError(t, gzipUntar(context.Background(), dst, ar, "/lib", DefaultArchiveLimits))
+
+	fi, err := os.Stat(filepath.Join(dst, "lib/main.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm()&^umask())
+
+	fi, err = os.Stat(filepath.Join(dst, "lib/run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm()&^umask())
+
+	link, err := os.Readlink(filepath.Join(dst, "lib/link.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "main.libsonnet", link)
+
+	// only lib/ is extracted, not everything with that prefix
+	assert.NoDirExists(t, filepath.Join(dst, "libfoo"))
+}
+
+// umask returns the bits removed by the process umask from 0777
+func umask() os.FileMode {
+	dir, err := os.MkdirTemp("", "umask")
+	if err != nil {
+		return 0
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "f")
+	if err := os.WriteFile(p, nil, 0777); err != nil {
+		return 0
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0
+	}
+	return 0777 &^ fi.Mode().Perm()
+}
+
+func TestIsLocal(t *testing.T) {
+	for path, want := range map[string]bool{
+		"":            false,
+		".":           true,
+		"a/b":         true,
+		"a/../b":      true,
+		"a/../..":     false,
+		"..":          false,
+		"../a":        false,
+		"/etc/passwd": false,
+		"..a":         true,
+	} {
+		assert.Equal(t, want, isLocal(filepath.FromSlash(path)), path)
+	}
+}
+
+func TestUntarRejects(t *testing.T) {
+	zeros := string(make([]byte, 4<<20))
+
+	cases := []struct {
+		name    string
+		entries []tarEntry
+		limits  ArchiveLimits
+		reason  string
+	}{
+		{
+			name:    "Traversal",
+			entries: []tarEntry{{name: "repo-sha/../../evil", content: "x"}},
+			reason:  "path escapes the destination",
+		},
+		{
+			name:    "TraversalInside",
+			entries: []tarEntry{{name: "repo-sha/lib/../../../evil", content: "x"}},
+			reason:  "path escapes the destination",
+		},
+		{
+			name:    "Absolute",
+			entries: []tarEntry{{name: "repo-sha//etc/evil", content: "x"}},
+			reason:  "path escapes the destination",
+		},
+		{
+			name: "ThroughSymlink",
+			entries: []tarEntry{
+				{name: "repo-sha/dir/", typeflag: tar.TypeDir},
+				{name: "repo-sha/link", typeflag: tar.TypeSymlink, link: "dir"},
+				{name: "repo-sha/link/evil", content: "x"},
+			},
+			reason: "parent directory is a symlink",
+		},
+		{
+			name: "Size",
+			entries: []tarEntry{
+				{name: "repo-sha/a", content: "12345"},
+				{name: "repo-sha/b", content: "67890"},
+			},
+			limits: ArchiveLimits{MaxSize: 8},
+			reason: "extracted size exceeds 8 bytes",
+		},
+		{
+			name: "Files",
+			entries: []tarEntry{
+				{name: "repo-sha/a"},
+				{name: "repo-sha/b"},
+				{name: "repo-sha/c"},
+			},
+			limits: ArchiveLimits{MaxFiles: 2},
+			reason: "more than 2 files",
+		},
+		{
+			name:    "Ratio",
+			entries: []tarEntry{{name: "repo-sha/bomb", content: zeros}},
+			limits:  ArchiveLimits{MaxRatio: 100},
+			reason:  "compression ratio exceeds 100",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			base := t.TempDir()
+			dst := filepath.Join(base, "a", "dst")
+
+			err := gzipUntar(context.Background(), dst, tarball(t, c.entries...), "", c.limits)
+
+			var archiveErr *ArchiveError
+			require.ErrorAs(t, err, &archiveErr)
+			assert.Equal(t, c.reason, archiveErr.Reason)
+			assert.NoFileExists(t, filepath.Join(base, "evil"))
+			assert.NoFileExists(t, filepath.Join(base, "a", "evil"))
+		})
+	}
+}
+
+func TestUntarSymlinks(t *testing.T) {
+	base := t.TempDir()
+	dst := filepath.Join(base, "dst")
+	require.NoError(t, os.WriteFile(filepath.Join(base, "target"), []byte("outside"), 0644))
+
+	ar := tarball(t,
+		tarEntry{name: "repo-sha/lib/absolute", typeflag: tar.TypeSymlink, link: "/etc/passwd"},
+		tarEntry{name: "repo-sha/lib/relative", typeflag: tar.TypeSymlink, link: "../../target"},
+		tarEntry{name: "repo-sha/lib/sibling", typeflag: tar.TypeSymlink, link: "../other"},
+		tarEntry{name: "repo-sha/other/file", content: "x"},
+		// a later entry replaces the link instead of writing through it
+		tarEntry{name: "repo-sha/lib/inside", typeflag: tar.TypeSymlink, link: "dup"},
+		tarEntry{name: "repo-sha/lib/inside", content: "replaced"},
+		// a/b is the package itself, so c would be its parent
+		tarEntry{name: "repo-sha/lib/a/b", typeflag: tar.TypeSymlink, link: ".."},
+		tarEntry{name: "repo-sha/lib/chained", typeflag: tar.TypeSymlink, link: "a/b/.."},
+		tarEntry{name: "repo-sha/lib/through", typeflag: tar.TypeSymlink, link: "a/b/other"},
+		// missing may still become a link, e.g. to ..
+		tarEntry{name: "repo-sha/lib/missing-parent", typeflag: tar.TypeSymlink, link: "missing/.."},
+		// a link must not redirect the ones resolved through it so far
+		tarEntry{name: "repo-sha/lib/a/b", typeflag: tar.TypeSymlink, link: "."},
+	)
+
+	rec := &recorder{}
+	ctx := WithReporter(context.Background(), rec)
+	require.NoError(t, gzipUntar(ctx, dst, ar, "lib", DefaultArchiveLimits))
+
+	for _, name := range []string{"absolute", "relative", "sibling", "chained", "missing-parent"} {
+		_, err := os.Lstat(filepath.Join(dst, "lib", name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	assert.Equal(t, []EventType{EventWarning, EventWarning, EventWarning, EventWarning, EventWarning, EventWarning}, rec.types())
+
+	link, err := os.Readlink(filepath.Join(dst, "lib/a/b"))
+	require.NoError(t, err)
+	assert.Equal(t, "..", link)
+	link, err = os.Readlink(filepath.Join(dst, "lib/through"))
+	require.NoError(t, err)
+	assert.Equal(t, "a/b/other", link)
+
+	fi, err := os.Lstat(filepath.Join(dst, "lib/inside"))
+	require.NoError(t, err)
+	assert.True(t, fi.Mode().IsRegular())
+	assert.NoFileExists(t, filepath.Join(dst, "lib/dup"))
+
+	outside, err := os.ReadFile(filepath.Join(base, "target"))
+	require.NoError(t, err)
+	assert.Equal(t, "outside", string(outside))
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config holds the user level configuration of jb, which is
+// independent of any single project.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// File is the name of the configuration file inside the jb directory of the
+// user's config dir (e.g. ~/.config/jb/config.json)
+const File = "config.json"
+
+// Config is the structure of the jb configuration file. Unset values keep
+// their defaults.
+type Config struct {
+	// Retry configures how failed network operations are retried
+	Retry Retry `json:"retry"`
+	// HTTP configures the network access of downloads and git
+	HTTP HTTP `json:"http"`
+	// Rewrite redirects git dependencies to other remotes, e.g. mirrors
+	Rewrite []Rewrite `json:"rewrite,omitempty"`
+}
+
+// Retry holds the settings of pkg.RetryPolicy
+type Retry struct {
+	Retries    *int      `json:"retries,omitempty"`
+	Backoff    *Duration `json:"backoff,omitempty"`
+	MaxBackoff *Duration `json:"maxBackoff,omitempty"`
+}
+
+// HTTP holds the settings of pkg.HTTPSettings
+type HTTP struct {
+	Proxy    string `json:"proxy,omitempty"`
+	NoProxy  string `json:"noProxy,omitempty"`
+	CAFile   string `json:"caFile,omitempty"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// Rewrite holds a rule of pkg.Rewrite, redirecting the git dependencies
+// matching From to the remote To
+type Rewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Duration is a time.Duration that is written as a string like "1m30s" in
+// json
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultPath returns the location of the configuration file used if none
+// was explicitly specified
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "jb", File), nil
+}
+
+// Load reads the configuration file at path. Unknown keys are an error, so
+// typos don't go unnoticed.
+func Load(path string) (Config, error) {
+	var c Config
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name:    "Empty",
+			content: `{}`,
+			want:    Config{},
+		},
+		{
+			name:    "Retry",
+			content: `{"retry": {"retries": 5, "backoff": "2s", "maxBackoff": "5m"}}`,
+			want: Config{
+				Retry: Retry{
+					Retries:    intPtr(5),
+					Backoff:    durationPtr(2 * time.Second),
+					MaxBackoff: durationPtr(5 * time.Minute),
+				},
+			},
+		},
+		{
+			name:    "HTTP",
+			content: `{"http": {"proxy": "proxy.corp:3128", "noProxy": ".corp", "caFile": "/etc/corp-ca.pem"}}`,
+			want: Config{
+				HTTP: HTTP{Proxy: "proxy.corp:3128", NoProxy: ".corp", CAFile: "/etc/corp-ca.pem"},
+			},
+		},
+		{
+			name:    "Rewrite",
+			content: `{"rewrite": [{"from": "github.com/*", "to": "https://git-mirror.corp/github/*"}]}`,
+			want: Config{
+				Rewrite: []Rewrite{{From: "github.com/*", To: "https://git-mirror.corp/github/*"}},
+			},
+		},
+		{
+			name:    "UnknownKey",
+			content: `{"retry": {"retires": 5}}`,
+			wantErr: true,
+		},
+		{
+			name:    "InvalidDuration",
+			content: `{"retry": {"backoff": "soon"}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), File)
+			require.NoError(t, ioutil.WriteFile(path, []byte(tt.content), 0644))
+
+			got, err := Load(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func intPtr(i int) *int { return &i }
+
+func durationPtr(d time.Duration) *Duration {
+	v := Duration(d)
+	return &v
+}
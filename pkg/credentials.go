@@ -0,0 +1,336 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Credential authenticates requests to a host using HTTP basic auth. Tokens
+// are passed as the password.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// header returns the value of an Authorization header carrying c
+func (c Credential) header() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+}
+
+// CredentialProvider looks up the credentials for private sources. They are
+// used for downloading archives and passed to git, for https remotes only.
+type CredentialProvider interface {
+	// Credential returns the credential for host, or nil if there is none
+	Credential(ctx context.Context, host string) (*Credential, error)
+}
+
+// DefaultCredentials returns the providers used by jb, in order of
+// precedence: environment variables, ~/.netrc and the git credential helper.
+func DefaultCredentials() CredentialProvider {
+	return NewCredentialChain(EnvCredentials{}, NetrcCredentials{}, GitCredentials{})
+}
+
+// NewCredentialChain returns a CredentialProvider asking each of providers in
+// turn, until one has a credential. Results are remembered per host.
+func NewCredentialChain(providers ...CredentialProvider) CredentialProvider {
+	return &credentialChain{providers: providers, known: map[string]*Credential{}}
+}
+
+type credentialChain struct {
+	providers []CredentialProvider
+
+	mu    sync.Mutex
+	known map[string]*Credential
+}
+
+func (c *credentialChain) Credential(ctx context.Context, host string) (*Credential, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cred, ok := c.known[host]; ok {
+		return cred, nil
+	}
+	for _, p := range c.providers {
+		cred, err := p.Credential(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if cred != nil {
+			c.known[host] = cred
+			return cred, nil
+		}
+	}
+	c.known[host] = nil
+	return nil, nil
+}
+
+// defaultUsername is used for tokens given without a username. GitHub accepts
+// any username along with a token, GitLab accepts this one.
+const defaultUsername = "oauth2"
+
+// EnvCredentials reads tokens from environment variables:
+//
+//   - JB_AUTH_<HOST>, with the host in upper case and any other characters
+//     than letters and digits replaced by underscores, e.g.
+//     JB_AUTH_GITHUB_COM. The value is either a token or username:token
+//   - GITHUB_TOKEN or GH_TOKEN for github.com
+//   - GITLAB_TOKEN for gitlab.com, or the host in GITLAB_HOST
+//   - CI_JOB_TOKEN for the host in CI_SERVER_HOST, as set by GitLab CI
+type EnvCredentials struct {
+	// Getenv defaults to os.Getenv
+	Getenv func(string) string
+}
+
+func (e EnvCredentials) Credential(_ context.Context, host string) (*Credential, error) {
+	getenv := e.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	if v := getenv(HostEnv(host)); v != "" {
+		if user, token, ok := strings.Cut(v, ":"); ok {
+			return &Credential{Username: user, Password: token}, nil
+		}
+		return &Credential{Username: defaultUsername, Password: v}, nil
+	}
+
+	switch {
+	case host == "github.com":
+		for _, name := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+			if v := getenv(name); v != "" {
+				return &Credential{Username: defaultUsername, Password: v}, nil
+			}
+		}
+	case host == orDefault(getenv("GITLAB_HOST"), "gitlab.com") && getenv("GITLAB_TOKEN") != "":
+		return &Credential{Username: defaultUsername, Password: getenv("GITLAB_TOKEN")}, nil
+	}
+
+	if host == getenv("CI_SERVER_HOST") && getenv("CI_JOB_TOKEN") != "" {
+		return &Credential{Username: "gitlab-ci-token", Password: getenv("CI_JOB_TOKEN")}, nil
+	}
+	return nil, nil
+}
+
+// HostEnv returns the name of the environment variable EnvCredentials reads
+// the credential for host from
+func HostEnv(host string) string {
+	name := []rune(strings.ToUpper(host))
+	for i, r := range name {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	return "JB_AUTH_" + string(name)
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// NetrcCredentials reads credentials from a .netrc file, like curl and git do
+type NetrcCredentials struct {
+	// Path of the file. Defaults to $NETRC, or .netrc in the home directory
+	// (_netrc on Windows)
+	Path string
+}
+
+func (n NetrcCredentials) Credential(_ context.Context, host string) (*Credential, error) {
+	path := n.Path
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		name := ".netrc"
+		if runtime.GOOS == "windows" {
+			name = "_netrc"
+		}
+		path = filepath.Join(home, name)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading netrc")
+	}
+	return parseNetrc(data, host), nil
+}
+
+// parseNetrc returns the login of the machine host in the netrc file data,
+// or the default login
+func parseNetrc(data []byte, host string) *Credential {
+	var (
+		match, def *Credential
+		current    *Credential
+	)
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Split(bufio.ScanWords)
+	next := func() string {
+		if s.Scan() {
+			return s.Text()
+		}
+		return ""
+	}
+
+	for s.Scan() {
+		switch s.Text() {
+		case "machine":
+			current = nil
+			if next() == host && match == nil {
+				match = &Credential{}
+				current = match
+			}
+		case "default":
+			current = nil
+			if def == nil {
+				def = &Credential{}
+				current = def
+			}
+		case "login":
+			if v := next(); current != nil {
+				current.Username = v
+			}
+		case "password":
+			if v := next(); current != nil {
+				current.Password = v
+			}
+		case "account":
+			next()
+		case "macdef":
+			// macro definitions end with an empty line, which is invisible
+			// to the word scanner. They are rare enough to stop here.
+			return firstCredential(match, def)
+		}
+	}
+	return firstCredential(match, def)
+}
+
+func firstCredential(creds ...*Credential) *Credential {
+	for _, c := range creds {
+		if c != nil && c.Password != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// GitCredentials asks the credential helpers configured in git, using
+// `git credential fill`. Prompting for a password is disabled.
+type GitCredentials struct{}
+
+func (GitCredentials) Credential(ctx context.Context, host string) (*Credential, error) {
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n\n", host))
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+
+	// no helper knows the host
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger(ctx).Debugf("git credential fill %s: %s", host, err)
+		return nil, nil
+	}
+
+	c := &Credential{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+	if c.Password == "" {
+		return nil, nil
+	}
+	if c.Username == "" {
+		c.Username = defaultUsername
+	}
+	return c, nil
+}
+
+type credentialKey struct{}
+
+// hostCredential is the credential passed down to archive downloads and git
+type hostCredential struct {
+	host string
+	*Credential
+}
+
+// withCredential returns a copy of ctx that authenticates archive downloads
+// and all git commands run by ExecGit against https://host/ using c.
+func withCredential(ctx context.Context, host string, c *Credential) context.Context {
+	return context.WithValue(ctx, credentialKey{}, hostCredential{host: host, Credential: c})
+}
+
+// credentialOf returns the credential of ctx, or nil if there is none
+func credentialOf(ctx context.Context) *Credential {
+	c, _ := ctx.Value(credentialKey{}).(hostCredential)
+	return c.Credential
+}
+
+// credentialHelper answers git with the credential in the environment of git,
+// see gitEnv
+const credentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$JB_GIT_USERNAME" "$JB_GIT_PASSWORD"; }; f`
+
+// gitConfig returns the git configuration, as key=value, git has to be run
+// with in ctx. It never holds the credential itself, as it may be passed on
+// the command line, visible to all users. Instead, the credential helpers are
+// replaced by one reading it from the environment.
+func gitConfig(ctx context.Context) []string {
+	c, _ := ctx.Value(credentialKey{}).(hostCredential)
+	if c.Credential == nil {
+		return nil
+	}
+	key := fmt.Sprintf("credential.https://%s.helper", c.host)
+	return []string{key + "=", key + "=" + credentialHelper}
+}
+
+// gitEnv returns the environment variables git has to be run with in ctx,
+// holding the credential for credentialHelper
+func gitEnv(ctx context.Context) []string {
+	c, _ := ctx.Value(credentialKey{}).(hostCredential)
+	if c.Credential == nil {
+		return nil
+	}
+	return []string{"JB_GIT_USERNAME=" + c.Username, "JB_GIT_PASSWORD=" + c.Password}
+}
@@ -0,0 +1,269 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestEnvCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		host string
+		want *Credential
+	}{
+		{
+			name: "Host",
+			env:  map[string]string{"JB_AUTH_GIT_EXAMPLE_COM": "s3cret"},
+			host: "git.example.com",
+			want: &Credential{Username: defaultUsername, Password: "s3cret"},
+		},
+		{
+			name: "HostUser",
+			env:  map[string]string{"JB_AUTH_GIT_EXAMPLE_COM": "bot:s3cret"},
+			host: "git.example.com",
+			want: &Credential{Username: "bot", Password: "s3cret"},
+		},
+		{
+			name: "OtherHost",
+			env:  map[string]string{"JB_AUTH_GIT_EXAMPLE_COM": "s3cret", "GITHUB_TOKEN": "gh"},
+			host: "example.com",
+		},
+		{
+			name: "GitHub",
+			env:  map[string]string{"GH_TOKEN": "gh"},
+			host: "github.com",
+			want: &Credential{Username: defaultUsername, Password: "gh"},
+		},
+		{
+			name: "HostBeforeGitHub",
+			env:  map[string]string{"GITHUB_TOKEN": "gh", "JB_AUTH_GITHUB_COM": "jb"},
+			host: "github.com",
+			want: &Credential{Username: defaultUsername, Password: "jb"},
+		},
+		{
+			name: "GitLab",
+			env:  map[string]string{"GITLAB_TOKEN": "gl"},
+			host: "gitlab.com",
+			want: &Credential{Username: defaultUsername, Password: "gl"},
+		},
+		{
+			name: "GitLabHost",
+			env:  map[string]string{"GITLAB_TOKEN": "gl", "GITLAB_HOST": "gitlab.example.com"},
+			host: "gitlab.com",
+		},
+		{
+			name: "CIJob",
+			env:  map[string]string{"CI_JOB_TOKEN": "job", "CI_SERVER_HOST": "gitlab.example.com"},
+			host: "gitlab.example.com",
+			want: &Credential{Username: "gitlab-ci-token", Password: "job"},
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			env := EnvCredentials{Getenv: func(name string) string { return c.env[name] }}
+			got, err := env.Credential(context.Background(), c.host)
+			require.NoError(t, err)
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+machine example.com login alice password one
+machine git.example.com
+  login bob
+  account ignored
+  password two
+default login anon password three
+macdef init
+  machine other.com login eve password four
+`), 0600))
+
+	netrc := NetrcCredentials{Path: path}
+	for host, want := range map[string]*Credential{
+		"example.com":     {Username: "alice", Password: "one"},
+		"git.example.com": {Username: "bob", Password: "two"},
+		"other.com":       {Username: "anon", Password: "three"},
+	} {
+		got, err := netrc.Credential(context.Background(), host)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, host)
+	}
+
+	missing := NetrcCredentials{Path: filepath.Join(t.TempDir(), "netrc")}
+	got, err := missing.Credential(context.Background(), "example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestGitCredentials(t *testing.T) {
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.https://git.example.com.helper")
+	t.Setenv("GIT_CONFIG_VALUE_0", "!f() { echo username=bob; echo password=s3cret; }; f")
+
+	got, err := GitCredentials{}.Credential(context.Background(), "git.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &Credential{Username: "bob", Password: "s3cret"}, got)
+
+	// no helper for the host, and no prompting
+	got, err = GitCredentials{}.Credential(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+// countingProvider counts the lookups, always returning the same credential
+type countingProvider struct {
+	calls *int
+	cred  *Credential
+}
+
+func (p countingProvider) Credential(context.Context, string) (*Credential, error) {
+	*p.calls++
+	return p.cred, nil
+}
+
+func TestCredentialChain(t *testing.T) {
+	var first, second int
+	want := &Credential{Username: "bob", Password: "s3cret"}
+	chain := NewCredentialChain(countingProvider{calls: &first}, countingProvider{calls: &second, cred: want})
+
+	for i := 0; i < 2; i++ {
+		got, err := chain.Credential(context.Background(), "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 1, second)
+}
+
+// configGit records the credential configuration of the git commands run, by
+// their first argument
+type configGit struct {
+	GitRunner
+	config map[string][]string
+}
+
+func (g configGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	g.config[args[0]] = gitConfig(ctx)
+	return g.GitRunner.Run(ctx, dir, stdout, stderr, args...)
+}
+
+func TestInstallCredentials(t *testing.T) {
+	repo := gitRepo(t)
+	source := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "foo", Repo: "bar", Subdir: "/lib"}
+	cred := &Credential{Username: "bot", Password: "s3cret-token"}
+
+	install := func(t *testing.T, transport http.RoundTripper) (*GitPackage, map[string][]string) {
+		vendorDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, ".tmp"), os.ModePerm))
+
+		git := configGit{GitRunner: localGit{remote: source.Remote(), dir: repo}, config: map[string][]string{}}
+		p := testGitPackage(RetryPolicy{})
+		p.Source = source
+		p.Git = git
+		p.HTTPClient = &http.Client{Transport: transport}
+		p.Credentials = NewCredentialChain(EnvCredentials{Getenv: func(name string) string {
+			return map[string]string{"JB_AUTH_GITHUB_COM": "bot:s3cret-token"}[name]
+		}})
+
+		_, err := p.Install(context.Background(), source.Name(), vendorDir, "v1")
+		require.NoError(t, err)
+		return p, git.config
+	}
+
+	t.Run("Archive", func(t *testing.T) {
+		p, config := install(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "api.github.com", r.URL.Host)
+			assert.True(t, strings.HasPrefix(r.URL.Path, "/repos/foo/bar/tarball/"), r.URL.Path)
+			user, pass, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, cred.Username, user)
+			assert.Equal(t, cred.Password, pass)
+			return archiveTransport{dir: repo}.RoundTrip(r)
+		}))
+		assert.Equal(t, MethodArchive, p.Method)
+		assert.Equal(t, []string{"credential.https://github.com.helper=", "credential.https://github.com.helper=" + credentialHelper}, config["ls-remote"])
+	})
+
+	t.Run("Git", func(t *testing.T) {
+		p, config := install(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("offline")
+		}))
+		assert.Equal(t, MethodGit, p.Method)
+		assert.Equal(t, []string{"credential.https://github.com.helper=", "credential.https://github.com.helper=" + credentialHelper}, config["fetch"])
+	})
+
+	// the token never shows up in the output
+	assert.Equal(t, "token "+redacted, Redact("token s3cret-token"))
+}
+
+func TestGitCredentialHelper(t *testing.T) {
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	// replaced by the one of jb
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.helper")
+	t.Setenv("GIT_CONFIG_VALUE_0", "!f() { echo username=other; echo password=wrong; }; f")
+
+	cred := &Credential{Username: "bot", Password: "s3cret-token"}
+	ctx := withCredential(context.Background(), "git.example.com", cred)
+
+	// older git gets the configuration on the command line, which all users
+	// can see: the credential must not be part of it
+	for _, viaEnv := range []bool{true, false} {
+		env, args := withConfig(gitConfig(ctx), viaEnv, []string{"credential", "fill"})
+		for _, arg := range args {
+			assert.NotContains(t, arg, cred.Password)
+			assert.NotContains(t, arg, strings.TrimPrefix(cred.header(), "Basic "))
+		}
+
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(append(os.Environ(), env...), gitEnv(ctx)...)
+		cmd.Stdin = strings.NewReader("protocol=https\nhost=git.example.com\n\n")
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		assert.Contains(t, string(out), "username=bot\npassword=s3cret-token\n")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	addSecret("hunter2-token")
+	addSecret("x")
+
+	assert.Equal(t, "GET https://***@example.com/repo", Redact("GET https://user:pw@example.com/repo"))
+	assert.Equal(t, "Authorization: Bearer ***", Redact("Authorization: Bearer hunter2-token"))
+	assert.Equal(t, "x marks the spot", Redact("x marks the spot"))
+}
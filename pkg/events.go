@@ -0,0 +1,119 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"time"
+)
+
+// EventType tells what happened during an installation
+type EventType string
+
+const (
+	// EventResolve: a package was settled on a version, either from the lock
+	// or from upstream
+	EventResolve EventType = "resolve"
+	// EventDownloadStart: retrieval of a package from upstream begins
+	EventDownloadStart EventType = "download-start"
+	// EventDownloadFinish: retrieval of a package ended. Error is set if it
+	// failed
+	EventDownloadFinish EventType = "download-finish"
+	// EventChecksum: the vendored files of a package were compared against
+	// the lock. OK holds the result
+	EventChecksum EventType = "checksum"
+	// EventClean: an unknown file or directory was removed from vendor/
+	EventClean EventType = "clean"
+	// EventLink: a symlink was created, either for a local package or for a
+	// legacy import name
+	EventLink EventType = "link"
+	// EventRetry: a network operation failed and is retried
+	EventRetry EventType = "retry"
+	// EventWarning: something unexpected that does not abort the installation
+	EventWarning EventType = "warning"
+)
+
+// Event describes a single step of an installation. Only the fields relevant
+// to the Type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Package is the name of the affected package
+	Package string `json:"package,omitempty"`
+	// Version is the requested version for EventDownloadStart and the locked
+	// one otherwise
+	Version string `json:"version,omitempty"`
+	// Sum is the checksum of the vendored files
+	Sum string `json:"sum,omitempty"`
+	// Path is the affected file or directory. For EventLink, it is the link
+	// itself and Target is where it points to
+	Path   string `json:"path,omitempty"`
+	Target string `json:"target,omitempty"`
+
+	// OK is the result of EventChecksum. It is a pointer, so a mismatch is
+	// reported as false rather than left out.
+	OK *bool `json:"ok,omitempty"`
+	// Error is set if the step failed
+	Error string `json:"error,omitempty"`
+	// Message is a human readable description for EventRetry and
+	// EventWarning
+	Message string `json:"message,omitempty"`
+}
+
+// Reporter receives the events of an installation
+type Reporter interface {
+	Report(Event)
+}
+
+// ReporterFunc is a function implementing Reporter
+type ReporterFunc func(Event)
+
+// Report calls f(e)
+func (f ReporterFunc) Report(e Event) {
+	f(e)
+}
+
+// MultiReporter sends each event to all of its members
+type MultiReporter []Reporter
+
+// Report calls Report of all members
+func (m MultiReporter) Report(e Event) {
+	for _, r := range m {
+		r.Report(e)
+	}
+}
+
+type reporterKey struct{}
+
+// WithReporter returns a copy of ctx that causes all installations using it
+// to report their events to r.
+func WithReporter(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, r)
+}
+
+// report sends e to the Reporter of ctx, if any
+func report(ctx context.Context, e Event) {
+	r, ok := ctx.Value(reporterKey{}).(Reporter)
+	if !ok {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Message, e.Error = Redact(e.Message), Redact(e.Error)
+	r.Report(e)
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// recorder collects all reported events
+type recorder []Event
+
+func (r *recorder) Report(e Event) {
+	*r = append(*r, e)
+}
+
+func (r recorder) types() []EventType {
+	types := make([]EventType, 0, len(r))
+	for _, e := range r {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestReportWithoutReporter(t *testing.T) {
+	// must not panic
+	report(context.Background(), Event{Type: EventClean})
+}
+
+func TestReportSetsTime(t *testing.T) {
+	var rec recorder
+	report(WithReporter(context.Background(), &rec), Event{Type: EventClean, Path: "vendor/foo"})
+
+	require.Len(t, rec, 1)
+	assert.Equal(t, EventClean, rec[0].Type)
+	assert.False(t, rec[0].Time.IsZero())
+}
+
+func TestEventJSON(t *testing.T) {
+	ok := false
+	b, err := json.Marshal(Event{Type: EventChecksum, Package: "foo", OK: &ok})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "checksum", "time": "0001-01-01T00:00:00Z", "package": "foo", "ok": false}`, string(b))
+
+	// only set for checksums
+	b, err = json.Marshal(Event{Type: EventClean, Path: "vendor/foo"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "clean", "time": "0001-01-01T00:00:00Z", "path": "vendor/foo"}`, string(b))
+}
+
+func TestEnsureReportsEvents(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	pkgDir, err := ioutil.TempDir(cwd, "foo")
+	require.NoError(t, err)
+	defer os.RemoveAll(pkgDir)
+
+	vendorDir, err := ioutil.TempDir(cwd, "vendor")
+	require.NoError(t, err)
+	defer os.RemoveAll(vendorDir)
+
+	// a leftover directory that must be cleaned
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, "unknown"), os.ModePerm))
+
+	rel, err := filepath.Rel(cwd, pkgDir)
+	require.NoError(t, err)
+
+	jf := deps.NewOrdered()
+	d := deps.Parse(cwd, rel)
+	require.NotNil(t, d)
+	jf.Set(d.Name(), *d)
+
+	var rec recorder
+	ctx := WithReporter(context.Background(), &rec)
+	_, err = Ensure(ctx, v1.JsonnetFile{Dependencies: jf}, vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	assert.Equal(t, []EventType{
+		EventDownloadStart,
+		EventLink,
+		EventDownloadFinish,
+		EventResolve,
+		EventClean,
+	}, rec.types())
+}
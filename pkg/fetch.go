@@ -0,0 +1,127 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// fetchCache keeps the archives and repositories retrieved during a single
+// run, so that packages living in different subdirectories of the same
+// repository share one download or clone. It lives below vendor/.tmp and is
+// removed along with it.
+type fetchCache struct {
+	dir string
+	// archives are the downloaded archives, keyed by remote@commit
+	archives map[string]string
+	// failed remembers archive downloads that failed, keyed by remote@commit
+	failed map[string]error
+	// repos are the repositories, keyed by remote. Set once initialized.
+	repos map[string]*gitClone
+	// partial records whether git supports partial clones, once known
+	partial *bool
+}
+
+// newFetchCache creates a fetchCache below the temporary directory of
+// vendorDir, which has to exist
+func newFetchCache(vendorDir string) (*fetchCache, error) {
+	dir, err := ioutil.TempDir(tmpDir(vendorDir), "fetch")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating fetch cache")
+	}
+	return &fetchCache{
+		dir:      dir,
+		archives: map[string]string{},
+		failed:   map[string]error{},
+		repos:    map[string]*gitClone{},
+	}, nil
+}
+
+// gitClone is a repository packages are extracted from
+type gitClone struct {
+	dir string
+	// partial is set if the repository is a partial clone, which is missing
+	// the contents of files until they are checked out
+	partial bool
+	// commits are the fetched versions and the commits they resolved to
+	commits map[string]string
+	// cone lists the checked out subdirectories, full is set if the
+	// complete tree is checked out
+	cone []string
+	full bool
+}
+
+// checkout checks out commit, limited to subdir and the ones checked out
+// before. In a partial clone, this fetches the contents of all files needed
+// at once, instead of one by one during git archive.
+func (r *gitClone) checkout(gitCmd, gitRetry func(args ...string) error, subdir, commit string) error {
+	switch {
+	case r.full:
+	case subdir == "":
+		r.full = true
+		if len(r.cone) > 0 {
+			if err := gitCmd("sparse-checkout", "disable"); err != nil {
+				return err
+			}
+		}
+	case !contains(r.cone, subdir):
+		// set --cone needs git 2.35, init --cone works since partial clones do
+		if len(r.cone) == 0 {
+			if err := gitCmd("sparse-checkout", "init", "--cone"); err != nil {
+				return err
+			}
+		}
+		r.cone = append(r.cone, subdir)
+		if err := gitCmd(append([]string{"sparse-checkout", "set"}, r.cone...)...); err != nil {
+			return err
+		}
+	}
+	return gitRetry("checkout", "--quiet", "--detach", commit)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// path returns a location in the cache that is unique to key
+func (c *fetchCache) path(kind, key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, kind+"-"+hex.EncodeToString(h[:16]))
+}
+
+type fetchCacheKey struct{}
+
+// withFetchCache returns a copy of ctx sharing retrievals through c
+func withFetchCache(ctx context.Context, c *fetchCache) context.Context {
+	return context.WithValue(ctx, fetchCacheKey{}, c)
+}
+
+// fetchCacheOf returns the fetchCache of ctx, or nil if there is none, in
+// which case every package is retrieved on its own
+func fetchCacheOf(ctx context.Context) *fetchCache {
+	c, _ := ctx.Value(fetchCacheKey{}).(*fetchCache)
+	return c
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// countingGit counts the git commands run, by their first argument
+type countingGit struct {
+	GitRunner
+	calls map[string]int
+}
+
+func (g countingGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	g.calls[args[0]]++
+	return g.GitRunner.Run(ctx, dir, stdout, stderr, args...)
+}
+
+func TestFetchOnce(t *testing.T) {
+	repo := gitRepo(t)
+	lib := *deps.Parse("", "github.com/foo/bar/lib@v1")
+	other := *deps.Parse("", "github.com/foo/bar/other@v1")
+
+	install := func(t *testing.T, transport http.RoundTripper) (map[string]int, *deps.Ordered) {
+		dir := t.TempDir()
+		jf := v1.New()
+		jf.Dependencies.Set(lib.Name(), lib)
+		jf.Dependencies.Set(other.Name(), other)
+		require.NoError(t, writeJSONFile(filepath.Join(dir, jsonnetfile.File), jf))
+
+		git := countingGit{GitRunner: localGit{remote: lib.Source.GitSource.Remote(), dir: repo}, calls: map[string]int{}}
+		i := Installer{
+			ProjectDir: dir,
+			Git:        git,
+			HTTPClient: &http.Client{Transport: transport},
+			Retry:      &RetryPolicy{},
+		}
+		res, err := i.Install(context.Background(), nil, InstallOptions{})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, DefaultVendorDir, lib.Name(), "main.libsonnet"))
+		assert.FileExists(t, filepath.Join(dir, DefaultVendorDir, other.Name(), "main.libsonnet"))
+		return git.calls, res.Locked
+	}
+
+	t.Run("Archive", func(t *testing.T) {
+		downloads := 0
+		calls, _ := install(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			downloads++
+			return archiveTransport{dir: repo}.RoundTrip(r)
+		}))
+		assert.Equal(t, 1, downloads)
+		assert.Equal(t, 0, calls["fetch"])
+	})
+
+	t.Run("Git", func(t *testing.T) {
+		downloads := 0
+		calls, locked := install(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			downloads++
+			return nil, errors.New("offline")
+		}))
+		assert.Equal(t, 1, downloads)
+		assert.Equal(t, 1, calls["fetch"])
+		assert.Equal(t, 1, calls["init"])
+
+		for _, name := range []string{lib.Name(), other.Name()} {
+			d, _ := locked.Get(name)
+			assert.Equal(t, MethodGit, d.Method)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockFile is the name of the file next to jsonnetfile.lock.json used to
+// prevent concurrent jb processes from modifying the same project. It only
+// exists while the lock is held.
+const LockFile = ".jb.lock"
+
+// ErrLocked is returned if another process holds the lock and waiting for it
+// was not allowed
+var ErrLocked = errors.New("another jb process is using this project")
+
+// lockPollInterval is how often a busy lock is retried
+var lockPollInterval = 100 * time.Millisecond
+
+// fileLock is an advisory, exclusive lock on a file, held until Unlock is
+// called or the process exits
+type fileLock struct {
+	f    *os.File
+	path string
+}
+
+// lockFile acquires the lock on path, creating the file if needed. Unless
+// wait is false, it blocks until the lock is available or ctx is done.
+func lockFile(ctx context.Context, path string, wait bool) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	waiting := false
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening lock file")
+		}
+
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "locking %s", path)
+		}
+		if ok {
+			// the previous holder may have removed the file in the meantime,
+			// in which case a new one has to be locked
+			if current(f, path) {
+				return &fileLock{f: f, path: path}, nil
+			}
+			unlock(f)
+			f.Close()
+			continue
+		}
+		f.Close()
+
+		if !wait {
+			return nil, ErrLocked
+		}
+
+		if !waiting {
+			report(ctx, Event{Type: EventWarning, Path: path, Message: "waiting for another jb process to finish"})
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// current reports whether f is still the file at path
+func current(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, pi)
+}
+
+// Unlock releases the lock and removes the file, so that it does not end up
+// in version control. Processes waiting for the old file notice the removal
+// and lock a new one.
+func (l *fileLock) Unlock() error {
+	defer l.f.Close()
+	// best effort, open files cannot be removed on Windows
+	os.Remove(l.path)
+	return unlock(l.f)
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFileNoWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vendor", LockFile)
+
+	l, err := lockFile(context.Background(), path, false)
+	require.NoError(t, err)
+
+	_, err = lockFile(context.Background(), path, false)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, l.Unlock())
+	assert.NoFileExists(t, path)
+	l, err = lockFile(context.Background(), path, false)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock())
+}
+
+func TestLockFileWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockFile)
+
+	l, err := lockFile(context.Background(), path, false)
+	require.NoError(t, err)
+	time.AfterFunc(50*time.Millisecond, func() { l.Unlock() })
+
+	rec := &recorder{}
+	l2, err := lockFile(WithReporter(context.Background(), rec), path, true)
+	require.NoError(t, err)
+	require.NoError(t, l2.Unlock())
+
+	assert.Equal(t, []EventType{EventWarning}, rec.types())
+}
+
+func TestLockFileWaitCancelled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockFile)
+
+	l, err := lockFile(context.Background(), path, false)
+	require.NoError(t, err)
+	defer l.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = lockFile(ctx, path, true)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestInstallerNoWait(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, `{"version": 1, "dependencies": [], "legacyImports": false}`, "")
+
+	i := Installer{ProjectDir: dir, NoWait: true}
+	l, err := lockFile(context.Background(), i.flockPath(), false)
+	require.NoError(t, err)
+
+	_, err = i.Install(context.Background(), nil, InstallOptions{})
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, l.Unlock())
+	_, err = i.Install(context.Background(), nil, InstallOptions{})
+	assert.NoError(t, err)
+}
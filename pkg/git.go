@@ -15,9 +15,7 @@
 package pkg
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -25,14 +23,17 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/pkg/errors"
 
 	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
@@ -40,138 +41,218 @@ import (
 
 type GitPackage struct {
 	Source *deps.Git
+
+	// HTTPClient is used to download archives
+	HTTPClient *http.Client
+	// Git runs all git commands
+	Git GitRunner
+	// Retry is applied to all network operations
+	Retry RetryPolicy
+	// RequestTimeout limits how long a single archive download or git command
+	// may take. Zero means no limit.
+	RequestTimeout time.Duration
+	// Limits guard against hostile archives
+	Limits ArchiveLimits
+	// Credentials are looked up for https remotes. May be nil.
+	Credentials CredentialProvider
+	// Rewrites redirect Source to another remote
+	Rewrites []Rewrite
+
+	// Method is set by Install to the way the package was retrieved, either
+	// MethodArchive or MethodGit
+	Method string
 }
 
+// Retrieval methods of git packages, as recorded in the lock file
+const (
+	// MethodArchive downloads a tarball from GitHub
+	MethodArchive = "archive"
+	// MethodGit fetches the repository using git
+	MethodGit = "git"
+)
+
 func NewGitPackage(source *deps.Git) Interface {
 	return &GitPackage{
-		Source: source,
+		Source:     source,
+		HTTPClient: http.DefaultClient,
+		Git:        ExecGit{},
+		Retry:      DefaultRetryPolicy,
+		Limits:     DefaultArchiveLimits,
 	}
 }
 
-var GitQuiet = false
+// GitRunner runs git commands
+type GitRunner interface {
+	// Run executes git using args in dir. stdout and stderr may be nil to
+	// discard the respective output.
+	Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error
+}
 
-func downloadGitHubArchive(filepath string, url string) error {
-	// Get the data
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	if !GitQuiet {
-		color.Cyan("GET %s %d", url, resp.StatusCode)
+// ExecGit is a GitRunner using the git binary from $PATH
+type ExecGit struct {
+	// Config is passed to every command, as key=value
+	Config []string
+	// Env is added to the environment of every command
+	Env []string
+}
+
+// Run implements GitRunner
+func (g ExecGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	env := append(append([]string{}, g.Env...), gitEnv(ctx)...)
+	if config := append(append([]string{}, g.Config...), gitConfig(ctx)...); len(config) > 0 {
+		var configVars []string
+		configVars, args = withConfig(config, configEnvSupported(), args)
+		env = append(env, configVars...)
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
 	}
+	return cmd.Run()
+}
 
-	defer resp.Body.Close()
+// execGitVersion is the output of `git version`, asked once per run
+var execGitVersion struct {
+	sync.Once
+	out string
+}
 
-	// Create the file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
+// configEnvSupported returns whether the installed git reads config from the
+// environment, which requires git 2.31 or newer
+func configEnvSupported() bool {
+	execGitVersion.Do(func() {
+		out, _ := exec.Command("git", "version").Output()
+		execGitVersion.out = string(out)
+	})
+	return gitVersionAtLeast(execGitVersion.out, 2, 31)
+}
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
+// withConfig returns the environment variables and arguments of a git command
+// passing config. Older versions of git silently ignore config in the
+// environment, so unless viaEnv it is passed as `-c key=value` instead, even
+// though it then shows up in the process list.
+func withConfig(config []string, viaEnv bool, args []string) ([]string, []string) {
+	if viaEnv {
+		return configEnv(config), args
 	}
 
-	return nil
+	cmdArgs := make([]string, 0, 2*len(config)+len(args))
+	for _, kv := range config {
+		cmdArgs = append(cmdArgs, "-c", kv)
+	}
+	return nil, append(cmdArgs, args...)
 }
 
-func gzipUntar(dst string, r io.Reader, subDir string) error {
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
-		return err
+// configEnv returns the environment variables passing config to git. Unlike
+// `-c`, they do not show up on the command line. This requires git 2.31 or
+// newer, older versions ignore them.
+func configEnv(config []string) []string {
+	if len(config) == 0 {
+		return nil
 	}
-	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
+	n := 0
+	if v, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT")); err == nil {
+		n = v
+	}
+	env := []string{fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+len(config))}
+	for i, kv := range config {
+		key, value, _ := strings.Cut(kv, "=")
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", n+i, key),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n+i, value),
+		)
+	}
+	return env
+}
 
-	for {
-		header, err := tr.Next()
-		switch {
-		case err == io.EOF:
-			return nil
+// withRequestTimeout derives a context for a single network operation,
+// honoring RequestTimeout
+func (p *GitPackage) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.RequestTimeout)
+}
 
-		case err != nil:
-			return err
+func (p *GitPackage) downloadGitHubArchive(ctx context.Context, filepath string, url string) error {
+	return p.Retry.Do(ctx, "GET "+url, func() error {
+		return p.downloadGitHubArchiveOnce(ctx, filepath, url)
+	})
+}
 
-		case header == nil:
-			continue
-		}
+func (p *GitPackage) downloadGitHubArchiveOnce(ctx context.Context, filepath string, url string) error {
+	ctx, cancel := p.withRequestTimeout(ctx)
+	defer cancel()
 
-		// strip the two first components of the path
-		parts := strings.SplitAfterN(header.Name, "/", 2)
-		if len(parts) < 2 {
-			continue
-		}
-		suffix := parts[1]
-		prefix := dst
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if c := credentialOf(ctx); c != nil {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
 
-		// reconstruct the target parh for the archive entry
-		target := filepath.Join(prefix, suffix)
+	// Get the data
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		// connection problems are usually transient
+		return retryable(err, 0)
+	}
+	defer resp.Body.Close()
 
-		// if subdir is provided and target is not under it, skip it
-		subDirPath := filepath.Join(prefix, subDir)
-		if subDir != "" && !strings.HasPrefix(target, subDirPath) {
-			continue
+	logger(ctx).Infof("GET %s %d", url, resp.StatusCode)
+	if resp.StatusCode != 200 {
+		err := fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		if ok, after := retryableStatus(resp, time.Now()); ok {
+			return retryable(err, after)
 		}
+		return err
+	}
 
-		// check the file type
-		switch header.Typeflag {
-
-		// create directories as needed
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-				return err
-			}
-
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
-				return err
-			}
-
-			err := func() error {
-				fmt.Println("opening regular file", target)
-				f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-
-				// copy over contents
-				if _, err := io.Copy(f, tr); err != nil {
-					return err
-				}
-				return nil
-			}()
-
-			if err != nil {
-				return err
-			}
-
-		case tar.TypeSymlink:
-			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
-				return err
-			}
+	// Create the file
+	out, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
-			if err := os.Symlink(header.Linkname, target); err != nil {
-				return err
-			}
-		}
+	// Write the body to file
+	body := io.Reader(resp.Body)
+	if p.Limits.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, p.Limits.MaxSize+1)
+	}
+	n, err := io.Copy(out, body)
+	if err != nil {
+		return retryable(err, 0)
+	}
+	if p.Limits.MaxSize > 0 && n > p.Limits.MaxSize {
+		return &ArchiveError{Reason: fmt.Sprintf("download exceeds %d bytes", p.Limits.MaxSize)}
 	}
+
+	return nil
 }
 
-func remoteResolveRef(ctx context.Context, remote string, ref string) (string, error) {
+func (p *GitPackage) remoteResolveRef(ctx context.Context, remote string, ref string) (string, error) {
 	b := &bytes.Buffer{}
-	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", "--tags", "--refs", "--quiet", remote, ref)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = b
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	err := p.Retry.Do(ctx, "git ls-remote "+remote, func() error {
+		ctx, cancel := p.withRequestTimeout(ctx)
+		defer cancel()
+
+		b.Reset()
+		errBuf := &bytes.Buffer{}
+		stderr := io.Writer(errBuf)
+		if w := logger(ctx).Writer(LevelWarn); w != nil {
+			stderr = io.MultiWriter(w, errBuf)
+		}
+		err := p.Git.Run(ctx, "", b, stderr, "ls-remote", "--heads", "--tags", "--refs", "--quiet", remote, ref)
+		return gitRetryable(ctx, err, errBuf.String())
+	})
 	if err != nil {
 		return "", err
 	}
@@ -180,7 +261,46 @@ func remoteResolveRef(ctx context.Context, remote string, ref string) (string, e
 	return commitSha, nil
 }
 
+// remote returns the remote the package is retrieved from
+func (p *GitPackage) remote() string {
+	return rewriteRemote(p.Rewrites, p.Source)
+}
+
+// authenticate returns a copy of ctx carrying the credential for the remote,
+// if there is one. Only https remotes are authenticated, ssh has its own
+// means.
+func (p *GitPackage) authenticate(ctx context.Context) (context.Context, error) {
+	if p.Credentials == nil || !strings.HasPrefix(p.remote(), deps.GitSchemeHTTPS) {
+		return ctx, nil
+	}
+	u, err := url.Parse(p.remote())
+	if err != nil {
+		return ctx, nil
+	}
+
+	c, err := p.Credentials.Credential(ctx, u.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up credentials for %s", u.Host)
+	}
+	if c == nil {
+		return ctx, nil
+	}
+
+	addSecret(c.Password)
+	addSecret(strings.TrimPrefix(c.header(), "Basic "))
+	logger(ctx).Debugf("using credentials for %s", u.Host)
+	return withCredential(ctx, u.Host, c), nil
+}
+
 func (p *GitPackage) Install(ctx context.Context, name, dir, version string) (string, error) {
+	if remote := p.remote(); remote != p.Source.Remote() {
+		logger(ctx).Debugf("retrieving %s from %s", name, remote)
+	}
+	ctx, err := p.authenticate(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	destPath := path.Join(dir, name)
 
 	pkgh := sha256.Sum256([]byte(fmt.Sprintf("jsonnetpkg-%s-%s", strings.Replace(name, "/", "-", -1), strings.Replace(version, "/", "-", -1))))
@@ -193,150 +313,352 @@ func (p *GitPackage) Install(ctx context.Context, name, dir, version string) (st
 
 	// Optimization for GitHub sources: download a tarball archive of the requested
 	// version instead of cloning the entire
-	isGitHubRemote, err := regexp.MatchString(`^(https|ssh)://github\.com/.+$`, p.Source.Remote())
+	isGitHubRemote, err := regexp.MatchString(`^(https|ssh)://github\.com/.+$`, p.remote())
 	if isGitHubRemote {
-		// Let git ls-remote decide if "version" is a ref or a commit SHA in the unlikely
-		// but possible event that a ref is comprised of 40 or more hex characters
-		commitSha, err := remoteResolveRef(ctx, p.Source.Remote(), version)
-
-		// If the ref resolution failed and "version" looks like a SHA,
-		// assume it is one and proceed.
-		commitShaPattern := regexp.MustCompile("^([0-9a-f]{40,})$")
-		if commitSha == "" && commitShaPattern.MatchString(version) {
-			commitSha = version
+		extracted := filepath.Join(tmpDir, MethodArchive)
+		commitSha, err := p.installArchive(ctx, extracted, version)
+		if err == nil {
+			p.Method = MethodArchive
+			return commitSha, move(filepath.Join(extracted, p.Source.Subdir), destPath)
 		}
 
-		archiveUrl := fmt.Sprintf("%s/archive/%s.tar.gz", strings.TrimSuffix(p.Source.Remote(), ".git"), commitSha)
-		archiveFilepath := fmt.Sprintf("%s.tar.gz", tmpDir)
-
-		defer os.Remove(archiveFilepath)
-		err = downloadGitHubArchive(archiveFilepath, archiveUrl)
-		if err == nil {
-			var ar *os.File
-			fmt.Println("opening archive file", archiveFilepath)
-			ar, err = os.Open(archiveFilepath)
-			defer ar.Close()
-			if err == nil {
-				// Extract the sub-directory (if any) from the archive
-				// If none specified, the entire archive is unpacked
-				err = gzipUntar(tmpDir, ar, p.Source.Subdir)
-
-				// Move the extracted directory to its final destination
-				if err == nil {
-					if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
-						panic(err)
-					}
-					if err := os.Rename(path.Join(tmpDir, p.Source.Subdir), destPath); err != nil {
-						panic(err)
-					}
-				}
-			}
+		// cancelled by the user: retrying with git would be pointless
+		if ctx.Err() != nil {
+			return "", ctx.Err()
 		}
 
-		if err == nil {
-			return commitSha, nil
+		// git would produce the very same tree
+		var archiveErr *ArchiveError
+		if errors.As(err, &archiveErr) {
+			return "", err
 		}
 
 		// The repository may be private or the archive download may not work
 		// for other reasons. In any case, fall back to the slower git-based installation.
-		color.Yellow("archive install failed: %s", err)
-		color.Yellow("retrying with git...")
-	}
-
-	gitCmd := func(args ...string) *exec.Cmd {
-		cmd := exec.CommandContext(ctx, "git", args...)
-		cmd.Stdin = os.Stdin
-		if GitQuiet {
-			cmd.Stdout = nil
-			cmd.Stderr = nil
-		} else {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		}
-		cmd.Dir = tmpDir
-		return cmd
+		report(ctx, Event{
+			Type:    EventWarning,
+			Package: name,
+			Message: fmt.Sprintf("archive install failed: %s. Retrying with git", err),
+		})
 	}
 
-	color.Yellow("git init")
-	cmd := gitCmd("init")
-	err = cmd.Run()
+	extracted := filepath.Join(tmpDir, MethodGit)
+	commitHash, err := p.installGit(ctx, tmpDir, extracted, version)
 	if err != nil {
 		return "", err
 	}
 
-	color.Yellow("git remote add origin", p.Source.Remote())
-	cmd = gitCmd("remote", "add", "origin", p.Source.Remote())
-	err = cmd.Run()
+	p.Method = MethodGit
+	return commitHash, move(filepath.Join(extracted, p.Source.Subdir), destPath)
+}
+
+// installArchive downloads the GitHub archive of version and extracts it to
+// dst. It returns the commit the archive was created from.
+func (p *GitPackage) installArchive(ctx context.Context, dst, version string) (string, error) {
+	// Let git ls-remote decide if "version" is a ref or a commit SHA in the unlikely
+	// but possible event that a ref is comprised of 40 or more hex characters
+	commitSha, err := p.remoteResolveRef(ctx, p.remote(), version)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	// If the ref resolution failed and "version" looks like a SHA,
+	// assume it is one and proceed.
+	commitShaPattern := regexp.MustCompile("^([0-9a-f]{40,})$")
+	if commitSha == "" && commitShaPattern.MatchString(version) {
+		commitSha = version
+	}
+
+	archiveUrl := fmt.Sprintf("%s/archive/%s.tar.gz", strings.TrimSuffix(p.remote(), ".git"), commitSha)
+	// archives of private repositories are only served by the API
+	if credentialOf(ctx) != nil {
+		repo := strings.TrimPrefix(strings.TrimSuffix(p.remote(), ".git"), "https://github.com/")
+		archiveUrl = fmt.Sprintf("https://api.github.com/repos/%s/tarball/%s", repo, commitSha)
+	}
+	// only used without a fetchCache
+	defer os.Remove(fmt.Sprintf("%s.tar.gz", dst))
+
+	archiveFilepath, err := p.fetchArchive(ctx, fmt.Sprintf("%s.tar.gz", dst), archiveUrl, commitSha)
 	if err != nil {
 		return "", err
 	}
 
-	// Attempt shallow fetch at specific revision
-	color.Yellow("git fetch --tags --depth 1 origin", version)
-	cmd = gitCmd("fetch", "--tags", "--depth", "1", "origin", version)
-	err = cmd.Run()
+	logger(ctx).Debugf("extracting %s", archiveFilepath)
+	ar, err := os.Open(archiveFilepath)
 	if err != nil {
-		// Fall back to normal fetch (all revisions)
-		cmd = gitCmd("fetch", "origin")
-		err = cmd.Run()
-		if err != nil {
-			return "", err
+		return "", err
+	}
+	defer ar.Close()
+
+	// Extract the sub-directory (if any) from the archive
+	// If none specified, the entire archive is unpacked
+	if err := gzipUntar(ctx, dst, ar, p.Source.Subdir, p.Limits); err != nil {
+		return "", err
+	}
+	return commitSha, nil
+}
+
+// fetchArchive downloads the archive of commit from url to dst, returning where
+// it was stored. Within a run, the archive of every commit of a repository is
+// only downloaded once and kept in the fetchCache, so it can be extracted for
+// several subdirectories.
+func (p *GitPackage) fetchArchive(ctx context.Context, dst, url, commit string) (string, error) {
+	cache := fetchCacheOf(ctx)
+	if cache == nil || commit == "" {
+		return dst, p.downloadGitHubArchive(ctx, dst, url)
+	}
+
+	key := p.remote() + "@" + commit
+	if path, ok := cache.archives[key]; ok {
+		logger(ctx).Debugf("reusing the archive of %s", key)
+		return path, nil
+	}
+	if err, ok := cache.failed[key]; ok {
+		return "", err
+	}
+
+	path := cache.path(MethodArchive, key) + ".tar.gz"
+	if err := p.downloadGitHubArchive(ctx, path, url); err != nil {
+		if ctx.Err() == nil {
+			cache.failed[key] = err
 		}
+		return "", err
 	}
+	cache.archives[key] = path
+	return path, nil
+}
+
+// installGit fetches version into a repository below tmpDir and extracts it to
+// dst using git archive. Going through an archive instead of a checkout
+// applies .gitattributes (export-ignore, export-subst) exactly like the
+// archives downloaded from GitHub, so both methods yield the same sum.
+//
+// If git supports it, only the commit is fetched, without any file contents
+// (a partial clone). A sparse checkout of the subdirectory then retrieves the
+// contents that are needed in one go.
+//
+// Should the sparse checkout fail, the complete revision is fetched instead.
+func (p *GitPackage) installGit(ctx context.Context, tmpDir, dst, version string) (string, error) {
+	return p.installGitRepo(ctx, tmpDir, dst, version, true)
+}
+
+func (p *GitPackage) installGitRepo(ctx context.Context, tmpDir, dst, version string, allowPartial bool) (string, error) {
+	repo := &gitClone{dir: filepath.Join(tmpDir, "repo"), commits: map[string]string{}}
+
+	// within a run, all packages of a repository share one repository
+	cache := fetchCacheOf(ctx)
+	shared := false
+	if cache != nil {
+		if repo, shared = cache.repos[p.remote()]; !shared {
+			repo = &gitClone{dir: cache.path("repo", p.remote()), commits: map[string]string{}}
+		}
+	}
+
+	gitRun := func(stdout io.Writer, args ...string) error {
+		ctx, cancel := p.withRequestTimeout(ctx)
+		defer cancel()
+
+		// keep the error message of git, even if its output is not shown
+		errBuf := &bytes.Buffer{}
+		stderr := io.Writer(errBuf)
+		shown := logger(ctx).Writer(LevelInfo)
+		if shown != nil {
+			stderr = io.MultiWriter(shown, errBuf)
+		}
 
-	// Sparse checkout optimization: if a Subdir is specified,
-	// there is no need to do a full checkout
-	if p.Source.Subdir != "" {
-		cmd = gitCmd("config", "core.sparsecheckout", "true")
-		err = cmd.Run()
-		if err != nil {
+		logger(ctx).Infof("git %s", strings.Join(args, " "))
+		err := p.Git.Run(ctx, repo.dir, stdout, stderr, args...)
+		if err == nil {
+			return nil
+		}
+		if msg := strings.TrimSpace(errBuf.String()); msg != "" && shown == nil {
+			err = errors.Wrap(err, Redact(msg))
+		}
+		// only network commands are retried, see gitRetry
+		return gitRetryable(ctx, err, errBuf.String())
+	}
+	gitCmd := func(args ...string) error {
+		return gitRun(logger(ctx).Writer(LevelInfo), args...)
+	}
+
+	// commands talking to the network are retried on failure
+	gitRetry := func(args ...string) error {
+		return p.Retry.Do(ctx, "git "+args[0], func() error {
+			return gitCmd(args...)
+		})
+	}
+
+	if !shared {
+		if err := os.MkdirAll(repo.dir, os.ModePerm); err != nil {
+			return "", err
+		}
+		repo.partial = allowPartial && p.partialClone(ctx, cache)
+		if err := gitCmd("init", "--quiet"); err != nil {
+			return "", err
+		}
+		if err := gitCmd("remote", "add", "origin", p.remote()); err != nil {
 			return "", err
 		}
+		if cache != nil {
+			cache.repos[p.remote()] = repo
+		}
+	}
 
-		glob := []byte(p.Source.Subdir + "/*\n")
-		err = ioutil.WriteFile(filepath.Join(tmpDir, ".git", "info", "sparse-checkout"), glob, 0644)
-		if err != nil {
+	commitHash, ok := repo.commits[version]
+	if ok {
+		logger(ctx).Debugf("reusing the repository of %s at %s", p.remote(), commitHash)
+	} else {
+		var err error
+		if commitHash, err = p.fetchGit(ctx, repo, gitRetry, version); err != nil {
 			return "", err
 		}
+		repo.commits[version] = commitHash
 	}
 
-	color.Yellow("git -c advice.detachedHead=false checkout", version)
-	cmd = gitCmd("-c", "advice.detachedHead=false", "checkout", version)
-	err = cmd.Run()
-	if err != nil {
-		return "", err
+	if repo.partial {
+		if err := repo.checkout(gitCmd, gitRetry, strings.Trim(p.Source.Subdir, "/"), commitHash); err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			logger(ctx).Warnf("sparse checkout of %s failed, fetching the complete revision: %s", p.remote(), err)
+
+			// start over with a regular clone, for all further packages too
+			if cache != nil {
+				full := false
+				cache.partial = &full
+				delete(cache.repos, p.remote())
+			}
+			if err := os.RemoveAll(repo.dir); err != nil {
+				return "", err
+			}
+			return p.installGitRepo(ctx, tmpDir, dst, version, false)
+		}
+	}
+	return p.gitArchive(ctx, gitRun, dst, commitHash)
+}
+
+// fetchGit fetches version into repo, returning its commit. Servers lacking
+// support for partial clones send everything, which is slower but works.
+func (p *GitPackage) fetchGit(ctx context.Context, repo *gitClone, gitRetry func(args ...string) error, version string) (string, error) {
+	fetch := []string{"fetch", "--quiet"}
+	if repo.partial {
+		fetch = append(fetch, "--filter=blob:none")
 	}
 
-	b := bytes.NewBuffer(nil)
-	cmd = exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
-	cmd.Stdout = b
-	cmd.Dir = tmpDir
-	err = cmd.Run()
+	// Attempt shallow fetch at specific revision. Without tags, the fetched
+	// revision is only known as FETCH_HEAD.
+	rev := "FETCH_HEAD"
+	err := gitRetry(append(fetch, "--no-tags", "--depth", "1", "origin", version)...)
 	if err != nil {
-		return "", err
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		// Fall back to normal fetch (all revisions)
+		rev = version
+		if err := gitRetry(append(fetch, "origin")...); err != nil {
+			return "", err
+		}
 	}
 
-	commitHash := strings.TrimSpace(b.String())
+	return p.resolveCommit(ctx, repo.dir, rev)
+}
 
-	err = os.RemoveAll(path.Join(tmpDir, ".git"))
-	if err != nil {
-		return "", err
+// partialClone returns whether the installed git supports partial clones and
+// cone mode sparse checkouts, asking it once per run
+func (p *GitPackage) partialClone(ctx context.Context, cache *fetchCache) bool {
+	if cache != nil && cache.partial != nil {
+		return *cache.partial
 	}
 
-	err = os.MkdirAll(path.Dir(destPath), os.ModePerm)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create parent path")
+	b := &bytes.Buffer{}
+	ok := false
+	if err := p.Git.Run(ctx, "", b, nil, "version"); err == nil {
+		ok = gitVersionAtLeast(b.String(), 2, 27)
+	}
+	if !ok {
+		logger(ctx).Debugf("git does not support partial clones, fetching complete revisions")
 	}
 
-	err = os.RemoveAll(destPath)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to clean previous destination path")
+	if cache != nil {
+		cache.partial = &ok
+	}
+	return ok
+}
+
+// gitVersionAtLeast returns whether the output of git version reports at
+// least major.minor
+func gitVersionAtLeast(out string, major, minor int) bool {
+	m := regexp.MustCompile(`git version (\d+)\.(\d+)`).FindStringSubmatch(out)
+	if m == nil {
+		return false
+	}
+	gotMajor, _ := strconv.Atoi(m[1])
+	gotMinor, _ := strconv.Atoi(m[2])
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// gitArchive extracts the subdirectory of the package at commitHash to dst
+func (p *GitPackage) gitArchive(ctx context.Context, gitRun func(stdout io.Writer, args ...string) error, dst, commitHash string) (string, error) {
+	args := []string{"archive", "--format=tar", "--prefix=" + MethodGit + "/", commitHash}
+	if subdir := strings.Trim(p.Source.Subdir, "/"); subdir != "" {
+		args = append(args, "--", subdir)
 	}
 
-	err = os.Rename(path.Join(tmpDir, p.Source.Subdir), destPath)
+	// stream the archive right into the extraction
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := untar(ctx, dst, pr, p.Source.Subdir, p.Limits, nil)
+		if err == nil {
+			// consume the padding after the end of the archive
+			_, err = io.Copy(ioutil.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	err := gitRun(pw, args...)
+	pw.CloseWithError(err)
+	if untarErr := <-done; err == nil {
+		err = untarErr
+	}
 	if err != nil {
-		return "", errors.Wrap(err, "failed to move package")
+		return "", errors.Wrap(err, "extracting git archive")
 	}
 
 	return commitHash, nil
 }
+
+// resolveCommit returns the commit version refers to in the repository at
+// dir, trying remote branches like git checkout would.
+func (p *GitPackage) resolveCommit(ctx context.Context, dir, version string) (string, error) {
+	for _, rev := range []string{version, "origin/" + version} {
+		b := &bytes.Buffer{}
+		err := p.Git.Run(ctx, dir, b, nil, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+		if err == nil {
+			return strings.TrimSpace(b.String()), nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+	}
+	return "", fmt.Errorf("unable to find revision %s", version)
+}
+
+// move renames the extracted package at src to dst, replacing whatever was
+// there before
+func move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create parent path")
+	}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return errors.Wrap(err, "failed to clean previous destination path")
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return errors.Wrap(err, "failed to move package")
+	}
+	return nil
+}
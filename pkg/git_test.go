@@ -0,0 +1,295 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestDownloadGitHubArchiveRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p := testGitPackage(RetryPolicy{Retries: 0})
+	p.RequestTimeout = 50 * time.Millisecond
+
+	err := p.downloadGitHubArchive(context.Background(), filepath.Join(t.TempDir(), "a.tar.gz"), srv.URL)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDownloadGitHubArchiveCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := testGitPackage(DefaultRetryPolicy).downloadGitHubArchive(ctx, filepath.Join(t.TempDir(), "a.tar.gz"), srv.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func testGitPackage(r RetryPolicy) *GitPackage {
+	p := NewGitPackage(&deps.Git{}).(*GitPackage)
+	p.Retry = r
+	return p
+}
+
+// localGit redirects all remotes to a local repository
+type localGit struct {
+	remote, dir string
+}
+
+func (g localGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	insteadOf := fmt.Sprintf("url.file://%s.insteadOf=%s", g.dir, g.remote)
+	return ExecGit{}.Run(ctx, dir, stdout, stderr, append([]string{"-c", insteadOf}, args...)...)
+}
+
+// archiveTransport serves GitHub archives created from a local repository
+type archiveTransport struct {
+	dir string
+}
+
+func (a archiveTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	commit := strings.TrimSuffix(path.Base(r.URL.Path), ".tar.gz")
+	out, err := exec.Command("git", "-C", a.dir, "archive", "--format=tar.gz", "--prefix=repo-"+commit+"/", commit).Output()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(out)),
+		Request:    r,
+	}, nil
+}
+
+// gitRepo creates a repository whose archives differ from its checkout
+func gitRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{
+		".gitattributes":       "ignored.txt export-ignore\n",
+		"lib/main.libsonnet":   "{}",
+		"lib/ignored.txt":      "not exported",
+		"lib/link.libsonnet":   "->main.libsonnet",
+		"lib/version.txt":      "$Format:%H$",
+		"lib/.gitattributes":   "version.txt export-subst\n",
+		"other/main.libsonnet": "{}",
+	})
+	require.NoError(t, os.Chmod(filepath.Join(dir, "lib/main.libsonnet"), 0755))
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"tag", "v1"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	return dir
+}
+
+func TestGitPackageMethodsAgree(t *testing.T) {
+	repo := gitRepo(t)
+	source := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "github.com", User: "foo", Repo: "bar", Subdir: "/lib"}
+
+	install := func(t *testing.T, transport http.RoundTripper) (*GitPackage, string, string) {
+		vendorDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, ".tmp"), os.ModePerm))
+
+		p := testGitPackage(RetryPolicy{})
+		p.Source = source
+		p.Git = localGit{remote: source.Remote(), dir: repo}
+		p.HTTPClient = &http.Client{Transport: transport}
+
+		commit, err := p.Install(context.Background(), source.Name(), vendorDir, "v1")
+		require.NoError(t, err)
+		return p, commit, filepath.Join(vendorDir, source.Name())
+	}
+
+	archive, archiveCommit, archiveDir := install(t, archiveTransport{dir: repo})
+	assert.Equal(t, MethodArchive, archive.Method)
+
+	// failing downloads fall back to git
+	git, gitCommit, gitDir := install(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("offline")
+	}))
+	assert.Equal(t, MethodGit, git.Method)
+	assert.Equal(t, archiveCommit, gitCommit)
+
+	for _, dir := range []string{archiveDir, gitDir} {
+		assert.NoFileExists(t, filepath.Join(dir, "ignored.txt"))
+		version, err := ioutil.ReadFile(filepath.Join(dir, "version.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, gitCommit, string(version))
+	}
+
+	archiveSum, err := HashDir(archiveDir)
+	require.NoError(t, err)
+	gitSum, err := HashDir(gitDir)
+	require.NoError(t, err)
+	assert.Equal(t, archiveSum, gitSum)
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// sparselessGit fails all sparse-checkout commands, like a git version
+// lacking them would
+type sparselessGit struct {
+	GitRunner
+}
+
+func (g sparselessGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	if args[0] == "sparse-checkout" {
+		return errors.New("git: 'sparse-checkout' is not a git command")
+	}
+	return g.GitRunner.Run(ctx, dir, stdout, stderr, args...)
+}
+
+func TestInstallGitSparseFallback(t *testing.T) {
+	repo := gitRepo(t)
+	cmd := exec.Command("git", "config", "uploadpack.allowFilter", "true")
+	cmd.Dir = repo
+	require.NoError(t, cmd.Run())
+
+	source := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "example.com", User: "foo", Repo: "bar", Subdir: "/lib"}
+	vendorDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, ".tmp"), os.ModePerm))
+
+	var calls [][]string
+	p := testGitPackage(RetryPolicy{})
+	p.Source = source
+	p.Git = recordingGit{
+		GitRunner: sparselessGit{localGit{remote: source.Remote(), dir: repo}},
+		version:   "git version 2.30.2\n",
+		calls:     &calls,
+	}
+
+	_, err := p.Install(context.Background(), source.Name(), vendorDir, "v1")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(vendorDir, source.Name(), "main.libsonnet"))
+
+	var cmds []string
+	for _, c := range calls {
+		cmds = append(cmds, strings.Join(c, " "))
+	}
+	assert.Contains(t, cmds, "fetch --quiet --filter=blob:none --no-tags --depth 1 origin v1")
+	assert.Contains(t, cmds, "fetch --quiet --no-tags --depth 1 origin v1")
+}
+
+func TestGitVersionAtLeast(t *testing.T) {
+	assert.True(t, gitVersionAtLeast("git version 2.39.5\n", 2, 27))
+	assert.True(t, gitVersionAtLeast("git version 2.27.0.windows.1\n", 2, 27))
+	assert.True(t, gitVersionAtLeast("git version 3.0.0 (Apple Git-150)\n", 2, 27))
+	assert.False(t, gitVersionAtLeast("git version 2.26.2\n", 2, 27))
+	assert.False(t, gitVersionAtLeast("git version 1.8.3.1\n", 2, 27))
+	assert.False(t, gitVersionAtLeast("", 2, 27))
+}
+
+// recordingGit records the arguments of all git commands
+type recordingGit struct {
+	GitRunner
+	version string
+	calls   *[][]string
+}
+
+func (g recordingGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	*g.calls = append(*g.calls, args)
+	if args[0] == "version" && g.version != "" {
+		_, err := io.WriteString(stdout, g.version)
+		return err
+	}
+	return g.GitRunner.Run(ctx, dir, stdout, stderr, args...)
+}
+
+func TestInstallGitPartial(t *testing.T) {
+	repo := gitRepo(t)
+	cmd := exec.Command("git", "config", "uploadpack.allowFilter", "true")
+	cmd.Dir = repo
+	require.NoError(t, cmd.Run())
+
+	source := &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "example.com", User: "foo", Repo: "bar", Subdir: "/lib"}
+	install := func(t *testing.T, version string) []string {
+		vendorDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(vendorDir, ".tmp"), os.ModePerm))
+
+		var calls [][]string
+		p := testGitPackage(RetryPolicy{})
+		p.Source = source
+		p.Git = recordingGit{GitRunner: localGit{remote: source.Remote(), dir: repo}, version: version, calls: &calls}
+
+		_, err := p.Install(context.Background(), source.Name(), vendorDir, "v1")
+		require.NoError(t, err)
+		assert.Equal(t, MethodGit, p.Method)
+		assert.FileExists(t, filepath.Join(vendorDir, source.Name(), "main.libsonnet"))
+		assert.NoFileExists(t, filepath.Join(vendorDir, source.Name(), "ignored.txt"))
+
+		var cmds []string
+		for _, c := range calls {
+			cmds = append(cmds, strings.Join(c, " "))
+		}
+		return cmds
+	}
+
+	cmds := install(t, "")
+	assert.Contains(t, cmds, "fetch --quiet --filter=blob:none --no-tags --depth 1 origin v1")
+	assert.Contains(t, cmds, "sparse-checkout init --cone")
+	assert.Contains(t, cmds, "sparse-checkout set lib")
+
+	// git versions supporting partial clones, but not set --cone
+	cmds = install(t, "git version 2.34.1\n")
+	assert.Contains(t, cmds, "fetch --quiet --filter=blob:none --no-tags --depth 1 origin v1")
+	assert.NotContains(t, cmds, "sparse-checkout set --cone lib")
+
+	// older versions of git fetch everything
+	cmds = install(t, "git version 2.20.1\n")
+	assert.Contains(t, cmds, "fetch --quiet --no-tags --depth 1 origin v1")
+	for _, c := range cmds {
+		assert.NotContains(t, c, "sparse-checkout")
+	}
+}
@@ -0,0 +1,607 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// DefaultVendorDir is the directory packages are vendored into, relative to
+// the project
+const DefaultVendorDir = "vendor"
+
+// Installer manages the vendored dependencies of a single jsonnet project. It
+// is what the `jb` commands are built upon and can be used to embed jb into
+// other programs. The zero value operates on the current working directory
+// using the default settings.
+type Installer struct {
+	// ProjectDir holds the jsonnetfile.json. Defaults to the working directory
+	ProjectDir string
+	// VendorDir is where packages are installed to. Relative paths are
+	// relative to ProjectDir, or WorkspaceDir if set. Defaults to
+	// DefaultVendorDir
+	VendorDir string
+	// WorkspaceDir holds the workspace file, if ProjectDir is a member of a
+	// workspace (see FindWorkspace). The lock and vendor directory are kept
+	// there, and hold the dependencies of all members.
+	WorkspaceDir string
+
+	// Logger receives diagnostic output. Defaults to the one of the context,
+	// if any (see WithLogger)
+	Logger Logger
+	// Reporter receives the progress of all operations. Defaults to the one of
+	// the context, if any (see WithReporter)
+	Reporter Reporter
+
+	// HTTPClient is used to download archives. Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Git runs all git commands. Defaults to ExecGit
+	Git GitRunner
+	// Retry is applied to all network operations. Defaults to
+	// DefaultRetryPolicy
+	Retry *RetryPolicy
+	// RequestTimeout limits how long a single archive download or git command
+	// may take. Zero means no limit.
+	RequestTimeout time.Duration
+	// Credentials are looked up for private https remotes. May be nil.
+	Credentials CredentialProvider
+	// Rewrites redirect git dependencies to other remotes, e.g. mirrors
+	Rewrites []Rewrite
+
+	// NoWait makes modifying operations fail with ErrLocked instead of
+	// waiting if another process is working on the same vendor directory
+	NoWait bool
+}
+
+// Result describes the outcome of an Installer operation
+type Result struct {
+	// Locked is the full list of dependencies as written to the lock file
+	Locked *deps.Ordered
+	// Downloaded lists the names of all packages retrieved from upstream
+	Downloaded []string
+	// Removed lists all files and directories removed from vendor
+	Removed []string
+}
+
+// InstallOptions customize Installer.Install
+type InstallOptions struct {
+	// Single installs the given packages without their dependencies
+	Single bool
+	// LegacyName overrides the legacy import name. Only valid for a single
+	// package
+	LegacyName string
+}
+
+func (i *Installer) projectDir() string {
+	if i.ProjectDir == "" {
+		return "."
+	}
+	return i.ProjectDir
+}
+
+func (i *Installer) vendorDir() string {
+	dir := i.VendorDir
+	if dir == "" {
+		dir = DefaultVendorDir
+	}
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(i.rootDir(), dir)
+}
+
+// context attaches the Logger and Reporter of i to ctx
+func (i *Installer) context(ctx context.Context) context.Context {
+	if i.Logger != nil {
+		ctx = WithLogger(ctx, i.Logger)
+	}
+	if i.Reporter != nil {
+		ctx = WithReporter(ctx, i.Reporter)
+	}
+	return ctx
+}
+
+// lock prevents other processes from modifying the project until the returned
+// function is called
+func (i *Installer) lock(ctx context.Context) (func(), error) {
+	l, err := lockFile(i.context(ctx), i.flockPath(), !i.NoWait)
+	if err != nil {
+		return nil, err
+	}
+	return func() { l.Unlock() }, nil
+}
+
+// flockPath returns the file locked by lock, next to the lock file
+func (i *Installer) flockPath() string {
+	return filepath.Join(filepath.Dir(i.LockPath()), LockFile)
+}
+
+func (i *Installer) gitPackage(source *deps.Git) *GitPackage {
+	p := NewGitPackage(source).(*GitPackage)
+	if i.HTTPClient != nil {
+		p.HTTPClient = i.HTTPClient
+	}
+	if i.Git != nil {
+		p.Git = i.Git
+	}
+	if i.Retry != nil {
+		p.Retry = *i.Retry
+	}
+	p.RequestTimeout = i.RequestTimeout
+	p.Credentials = i.Credentials
+	p.Rewrites = i.Rewrites
+	return p
+}
+
+// Install adds the packages at the given uris to the jsonnetfile and makes
+// sure all dependencies are vendored at their locked version. Without uris,
+// it just restores vendor from the lock.
+func (i *Installer) Install(ctx context.Context, uris []string, opts InstallOptions) (*Result, error) {
+	ds := make([]deps.Dependency, 0, len(uris))
+	for _, u := range uris {
+		d := deps.Parse(i.projectDir(), u)
+		if d == nil {
+			// other members of the workspace can be installed by name
+			d = i.workspaceMember(u)
+		}
+		if d == nil {
+			return nil, fmt.Errorf("unable to parse package URI `%s`", u)
+		}
+		ds = append(ds, *d)
+	}
+
+	return i.InstallDependencies(ctx, ds, opts)
+}
+
+// InstallDependencies is like Install, but takes already parsed dependencies
+func (i *Installer) InstallDependencies(ctx context.Context, ds []deps.Dependency, opts InstallOptions) (*Result, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := i.projectDir()
+
+	jbfile, err := jsonnetfile.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jbfilebytes, err := jsonnetfile.ReadJSON(jbfile)
+	if err != nil && !(os.IsNotExist(err) && len(ds) == 0 && i.workspaceRoot()) {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	jsonnetFile, err := jsonnetfile.Unmarshal(jbfilebytes)
+	if err != nil {
+		return nil, err
+	}
+
+	jblockfilebytes, err := ioutil.ReadFile(i.LockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+
+	lockFile, err := jsonnetfile.Unmarshal(jblockfilebytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ds) > 1 && opts.LegacyName != "" {
+		return nil, errors.New("cannot use a legacy name with multiple uris")
+	}
+
+	for _, d := range ds {
+		if opts.Single {
+			d.Single = true
+		}
+
+		if opts.LegacyName != "" {
+			d.LegacyNameCompat = opts.LegacyName
+		}
+
+		jd, _ := jsonnetFile.Dependencies.Get(d.Name())
+		if !depEqual(jd, d) {
+			if err := writable(jbfile); err != nil {
+				return nil, err
+			}
+
+			// the dep passed on the cli is different from the jsonnetFile
+			jsonnetFile.Dependencies.Set(d.Name(), d)
+
+			// we want to install the passed version (ignore the lock)
+			lockFile.Dependencies.Delete(d.Name())
+		}
+	}
+
+	res, err := i.ensureCollect(ctx, jsonnetFile, lockFile.Dependencies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install packages")
+	}
+
+	CleanLegacyName(jsonnetFile.Dependencies)
+
+	if err := writeChangedJsonnetFile(jbfilebytes, &jsonnetFile, jbfile); err != nil {
+		return nil, errors.Wrapf(err, "updating %s", filepath.Base(jbfile))
+	}
+
+	if err := writeChangedJsonnetFile(jblockfilebytes, &v1.JsonnetFile{Dependencies: res.Locked}, i.LockPath()); err != nil {
+		return nil, errors.Wrap(err, "updating jsonnetfile.lock.json")
+	}
+
+	return res, nil
+}
+
+// Update retrieves the latest versions allowed by the jsonnetfile of the
+// packages at the given uris, or of all packages if none are given.
+func (i *Installer) Update(ctx context.Context, uris []string) (*Result, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := i.projectDir()
+
+	jbfile, err := jsonnetfile.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonnetFile, err := jsonnetfile.Load(jbfile)
+	if err != nil && !(os.IsNotExist(err) && i.workspaceRoot()) {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	lockFile, err := jsonnetfile.Load(i.LockPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+
+	locks := lockFile.Dependencies
+
+	for _, u := range uris {
+		d := deps.Parse(dir, u)
+		if d == nil {
+			return nil, fmt.Errorf("unable to parse package URI `%s`", u)
+		}
+
+		locks.Delete(d.Name())
+	}
+
+	// no uris: update all
+	if len(uris) == 0 {
+		locks = deps.NewOrdered()
+	}
+
+	res, err := i.ensureCollect(ctx, jsonnetFile, locks)
+	if err != nil {
+		return nil, errors.Wrap(err, "updating")
+	}
+
+	if err := writeJSONFile(i.LockPath(), v1.JsonnetFile{Dependencies: res.Locked}); err != nil {
+		return nil, errors.Wrap(err, "updating jsonnetfile.lock.json")
+	}
+
+	return res, nil
+}
+
+// Remove drops the given direct dependencies from the jsonnetfile. Packages
+// that are no longer required by anything are removed from vendor and the
+// lock. Dependencies can be given by uri or by name.
+func (i *Installer) Remove(ctx context.Context, uris []string) (*Result, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := i.projectDir()
+
+	jbfile, err := jsonnetfile.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonnetFile, err := jsonnetfile.Load(jbfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	lockFile, err := jsonnetfile.Load(i.LockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+
+	if err := writable(jbfile); err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, u := range uris {
+		name := u
+		if d := deps.Parse(dir, u); d != nil {
+			name = d.Name()
+		}
+
+		if _, ok := jsonnetFile.Dependencies.Get(name); !ok {
+			unknown = append(unknown, u)
+			continue
+		}
+		jsonnetFile.Dependencies.Delete(name)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("not a direct dependency: %s", strings.Join(unknown, ", "))
+	}
+
+	res, err := i.ensureCollect(ctx, jsonnetFile, lockFile.Dependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jsonnetfile.Write(jbfile, jsonnetFile); err != nil {
+		return nil, errors.Wrapf(err, "updating %s", filepath.Base(jbfile))
+	}
+	if err := writeJSONFile(i.LockPath(), v1.JsonnetFile{Dependencies: res.Locked}); err != nil {
+		return nil, errors.Wrap(err, "updating jsonnetfile.lock.json")
+	}
+
+	return res, nil
+}
+
+// MoveResult describes the outcome of Installer.Move
+type MoveResult struct {
+	Result
+	// From is the dependency as it was declared before
+	From deps.Dependency
+	// To is the dependency as it is declared now
+	To deps.Dependency
+}
+
+// Move replaces the direct dependency from, given by uri or by name, with to.
+// If to has no version, the declared version of from is kept, as well as the
+// locked one, so moving to a mirror does not update the package. The package
+// is vendored again from its new location.
+func (i *Installer) Move(ctx context.Context, from string, to deps.Dependency) (*MoveResult, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := i.projectDir()
+
+	jbfile, err := jsonnetfile.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonnetFile, err := jsonnetfile.Load(jbfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	lockFile, err := jsonnetfile.Load(i.LockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+
+	if err := writable(jbfile); err != nil {
+		return nil, err
+	}
+
+	name := from
+	if d := deps.Parse(dir, from); d != nil {
+		name = d.Name()
+	}
+	old, ok := jsonnetFile.Dependencies.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("not a direct dependency: %s", from)
+	}
+	if _, taken := jsonnetFile.Dependencies.Get(to.Name()); taken && to.Name() != old.Name() {
+		return nil, fmt.Errorf("%s is already a dependency", to.Name())
+	}
+
+	keepVersion := to.Version == ""
+	if keepVersion {
+		to.Version = old.Version
+	}
+	to.Single = old.Single
+	to.LegacyNameCompat = old.LegacyNameCompat
+
+	jsonnetFile.Dependencies.Delete(old.Name())
+	jsonnetFile.Dependencies.Set(to.Name(), to)
+
+	locks := lockFile.Dependencies
+	locked, wasLocked := locks.Get(old.Name())
+	locks.Delete(old.Name())
+	if wasLocked && keepVersion && locked.Source.GitSource != nil && to.Source.GitSource != nil {
+		// the same commit, but downloaded and checksummed again
+		locks.Set(to.Name(), deps.Dependency{Source: to.Source, Version: locked.Version, Single: to.Single})
+	}
+
+	res, err := i.ensureCollect(ctx, jsonnetFile, locks)
+	if err != nil {
+		return nil, errors.Wrapf(err, "moving %s", old.Name())
+	}
+
+	if err := jsonnetfile.Write(jbfile, jsonnetFile); err != nil {
+		return nil, errors.Wrapf(err, "updating %s", filepath.Base(jbfile))
+	}
+	if err := writeJSONFile(i.LockPath(), v1.JsonnetFile{Dependencies: res.Locked}); err != nil {
+		return nil, errors.Wrap(err, "updating jsonnetfile.lock.json")
+	}
+
+	return &MoveResult{Result: *res, From: old, To: to}, nil
+}
+
+// VerifyResult lists the outcome of Installer.Verify per package
+type VerifyResult struct {
+	// OK lists all packages matching the lock
+	OK []string
+	// Mismatched lists all packages whose files differ from the lock
+	Mismatched []ChecksumError
+	// Missing lists all packages that are locked but not vendored
+	Missing []string
+}
+
+// Valid returns whether vendor matches the lock exactly
+func (r VerifyResult) Valid() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0
+}
+
+// Verify checks the vendored packages against the sums recorded in the lock,
+// without accessing the network.
+func (i *Installer) Verify(ctx context.Context) (*VerifyResult, error) {
+	ctx = i.context(ctx)
+	vendorDir := i.vendorDir()
+
+	lockFile, err := jsonnetfile.Load(i.LockPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+
+	res := &VerifyResult{}
+	for _, k := range lockFile.Dependencies.Keys() {
+		d, _ := lockFile.Dependencies.Get(k)
+		dir := filepath.Join(vendorDir, d.Name())
+
+		exists, err := jsonnetfile.Exists(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			res.Missing = append(res.Missing, d.Name())
+			continue
+		}
+
+		// local packages are not checksummed
+		if d.Source.LocalSource != nil {
+			res.OK = append(res.OK, d.Name())
+			continue
+		}
+
+		expected := lockedSum(d)
+		sum, err := sumLike(ctx, dir, expected)
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing %s", d.Name())
+		}
+		ok := sum == expected
+		report(ctx, Event{Type: EventChecksum, Package: d.Name(), Version: d.Version, Sum: sum, Path: dir, OK: &ok})
+		if !ok {
+			res.Mismatched = append(res.Mismatched, ChecksumError{Package: d.Name(), Expected: expected, Actual: sum})
+			continue
+		}
+		res.OK = append(res.OK, d.Name())
+	}
+
+	return res, nil
+}
+
+// ensureCollect runs Ensure, collecting its events into a Result
+func (i *Installer) ensureCollect(ctx context.Context, direct v1.JsonnetFile, locks *deps.Ordered) (*Result, error) {
+	ctx = i.context(ctx)
+
+	if i.WorkspaceDir != "" {
+		var err error
+		if direct, err = i.workspaceDirect(ctx, direct); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &Result{}
+	var mu sync.Mutex
+	collect := ReporterFunc(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case e.Type == EventDownloadFinish && e.Error == "":
+			res.Downloaded = append(res.Downloaded, e.Package)
+		case e.Type == EventClean:
+			res.Removed = append(res.Removed, e.Path)
+		}
+	})
+
+	if parent, ok := ctx.Value(reporterKey{}).(Reporter); ok {
+		ctx = WithReporter(ctx, MultiReporter{parent, collect})
+	} else {
+		ctx = WithReporter(ctx, collect)
+	}
+
+	locked, err := i.ensureVendor(ctx, direct, locks)
+	if err != nil {
+		return nil, err
+	}
+	res.Locked = locked
+	return res, nil
+}
+
+func depEqual(d1, d2 deps.Dependency) bool {
+	name := d1.Name() == d2.Name()
+	version := d1.Version == d2.Version
+	source := reflect.DeepEqual(d1.Source, d2.Source)
+
+	return name && version && source
+}
+
+func writeJSONFile(name string, d interface{}) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding json")
+	}
+	b = append(b, []byte("\n")...)
+
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+func writeChangedJsonnetFile(originalBytes []byte, modified *v1.JsonnetFile, path string) error {
+	origJsonnetFile, err := jsonnetfile.Unmarshal(originalBytes)
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(origJsonnetFile, *modified) {
+		return nil
+	}
+
+	return jsonnetfile.Write(path, *modified)
+}
+
+// writable fails if the jsonnetfile at path cannot be changed by jb. Checked
+// before touching vendor/, so nothing needs to be undone.
+func writable(path string) error {
+	if jsonnetfile.FormatOf(path) == jsonnetfile.FormatJsonnet {
+		return jsonnetfile.ErrNotWritable
+	}
+	return nil
+}
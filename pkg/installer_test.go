@@ -0,0 +1,278 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestWriteChangedJsonnetFile(t *testing.T) {
+	testcases := []struct {
+		Name             string
+		JsonnetFileBytes []byte
+		NewJsonnetFile   v1.JsonnetFile
+		ExpectWrite      bool
+	}{
+		{
+			Name:             "NoDiffEmpty",
+			JsonnetFileBytes: []byte(`{}`),
+			NewJsonnetFile:   v1.New(),
+			ExpectWrite:      false,
+		},
+		{
+			Name:             "NoDiffNotEmpty",
+			JsonnetFileBytes: []byte(`{"dependencies": [{"version": "master"}]}`),
+			NewJsonnetFile: v1.JsonnetFile{
+				Dependencies: addDependencies(deps.NewOrdered(),
+					deps.Dependency{
+						Version: "master",
+					}),
+			},
+			ExpectWrite: false,
+		},
+		{
+			Name:             "DiffVersion",
+			JsonnetFileBytes: []byte(`{"dependencies": [{"version": "1.0"}]}`),
+			NewJsonnetFile: v1.JsonnetFile{
+				Dependencies: addDependencies(deps.NewOrdered(),
+					deps.Dependency{
+						Version: "2.0",
+					}),
+			},
+			ExpectWrite: true,
+		},
+		{
+			Name:             "Diff",
+			JsonnetFileBytes: []byte(`{}`),
+			NewJsonnetFile: v1.JsonnetFile{
+				Dependencies: addDependencies(deps.NewOrdered(),
+					deps.Dependency{
+						Source: deps.Source{
+							GitSource: &deps.Git{
+								Scheme: deps.GitSchemeHTTPS,
+								Host:   "github.com",
+								User:   "foobar",
+								Repo:   "foobar",
+								Subdir: "",
+							},
+						},
+						Version: "master",
+					}),
+			},
+			ExpectWrite: true,
+		},
+	}
+	outputjsonnetfile := filepath.Join(t.TempDir(), "changedjsonnet.json")
+	for _, tc := range testcases {
+		_ = t.Run(tc.Name, func(t *testing.T) {
+			clean := func() {
+				_ = os.Remove(outputjsonnetfile)
+			}
+			clean()
+			defer clean()
+
+			err := writeChangedJsonnetFile(tc.JsonnetFileBytes, &tc.NewJsonnetFile, outputjsonnetfile)
+			assert.NoError(t, err)
+
+			if tc.ExpectWrite {
+				assert.FileExists(t, outputjsonnetfile)
+			} else {
+				_, err := os.Lstat(outputjsonnetfile)
+				if err != nil {
+					assert.True(t, os.IsNotExist(err))
+				}
+			}
+		})
+	}
+}
+
+func addDependencies(o *deps.Ordered, ds ...deps.Dependency) *deps.Ordered {
+	for _, d := range ds {
+		o.Set(d.Name(), d)
+	}
+	return o
+}
+
+func writeProject(t *testing.T, dir, jsonnetFile, lockFile string) {
+	t.Helper()
+
+	files := map[string]string{jsonnetfile.File: jsonnetFile}
+	if lockFile != "" {
+		files[jsonnetfile.LockFile] = lockFile
+	}
+	testutil.WriteFiles(t, dir, files)
+}
+
+func TestInstallerInstallAndRemoveLocal(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, `{"version": 1, "dependencies": [], "legacyImports": false}`, "")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib", "foo"), os.ModePerm))
+
+	var rec recorder
+	i := Installer{ProjectDir: dir, Reporter: &rec}
+
+	res, err := i.Install(context.Background(), []string{"lib/foo"}, InstallOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, res.Downloaded)
+	assert.Equal(t, []string{"foo"}, res.Locked.Keys())
+	assert.Contains(t, rec.types(), EventLink)
+
+	target, err := os.Readlink(filepath.Join(dir, "vendor", "foo"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "lib", "foo"), target)
+
+	jf, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, jf.Dependencies.Keys())
+
+	res, err = i.Remove(context.Background(), []string{"foo"})
+	require.NoError(t, err)
+	assert.Empty(t, res.Locked.Keys())
+
+	_, err = os.Lstat(filepath.Join(dir, "vendor", "foo"))
+	assert.True(t, os.IsNotExist(err))
+
+	jf, err = jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	require.NoError(t, err)
+	assert.Empty(t, jf.Dependencies.Keys())
+}
+
+func TestInstallerJsonnetManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `local lib(name) = { source: { 'local': { directory: 'lib/' + name } }, version: '' };
+{ version: 1, dependencies: [lib('foo')], legacyImports: false }`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, jsonnetfile.FileJsonnet), []byte(manifest), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib", "foo"), os.ModePerm))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib", "bar"), os.ModePerm))
+
+	i := Installer{ProjectDir: dir}
+	res, err := i.Install(context.Background(), nil, InstallOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, res.Locked.Keys())
+
+	// the lock is still JSON
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, lock.Dependencies.Keys())
+
+	// changes have to be made by hand
+	_, err = i.Install(context.Background(), []string{"lib/bar"}, InstallOptions{})
+	assert.Equal(t, jsonnetfile.ErrNotWritable, err)
+	_, err = i.Remove(context.Background(), []string{"foo"})
+	assert.Equal(t, jsonnetfile.ErrNotWritable, err)
+
+	_, err = os.Lstat(filepath.Join(dir, "vendor", "bar"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallerRemoveUnknown(t *testing.T) {
+	dir := t.TempDir()
+	writeProject(t, dir, `{"version": 1, "dependencies": []}`, "")
+
+	i := Installer{ProjectDir: dir}
+	_, err := i.Remove(context.Background(), []string{"github.com/foo/bar"})
+	assert.EqualError(t, err, "not a direct dependency: github.com/foo/bar")
+}
+
+func TestInstallerVerify(t *testing.T) {
+	dir := t.TempDir()
+
+	pkgDir := filepath.Join(dir, "vendor", "github.com", "foo", "bar")
+	require.NoError(t, os.MkdirAll(pkgDir, os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pkgDir, "main.libsonnet"), []byte("{}"), 0644))
+	sum := hashDir(context.Background(), pkgDir)
+
+	lock := `{"version": 1, "dependencies": [
+		{"source": {"git": {"remote": "https://github.com/foo/bar.git", "subdir": ""}}, "version": "v1", "sum": "` + sum + `"},
+		{"source": {"git": {"remote": "https://github.com/foo/missing.git", "subdir": ""}}, "version": "v1", "sum": "` + sum + `"}
+	]}`
+	writeProject(t, dir, `{"version": 1, "dependencies": []}`, lock)
+
+	i := Installer{ProjectDir: dir}
+	res, err := i.Verify(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github.com/foo/bar"}, res.OK)
+	assert.Equal(t, []string{"github.com/foo/missing"}, res.Missing)
+	assert.False(t, res.Valid())
+
+	// tamper with the vendored files
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pkgDir, "main.libsonnet"), []byte("{ evil: true }"), 0644))
+
+	res, err = i.Verify(context.Background())
+	require.NoError(t, err)
+	require.Len(t, res.Mismatched, 1)
+	assert.Equal(t, "github.com/foo/bar", res.Mismatched[0].Package)
+	assert.Equal(t, sum, res.Mismatched[0].Expected)
+}
+
+func TestInstallerMove(t *testing.T) {
+	ctx := context.Background()
+	repo := gitRepo(t)
+	dir := t.TempDir()
+	writeProject(t, dir, `{"version": 1, "dependencies": [], "legacyImports": false}`, "")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib", "foo"), os.ModePerm))
+
+	i := Installer{
+		ProjectDir: dir,
+		Git:        localGit{remote: "https://github.com/foo/bar.git", dir: repo},
+		HTTPClient: &http.Client{Transport: archiveTransport{dir: repo}},
+		Retry:      &RetryPolicy{},
+	}
+	_, err := i.Install(ctx, []string{"github.com/foo/bar/lib@v1", "lib/foo"}, InstallOptions{})
+	require.NoError(t, err)
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	before, _ := lock.Dependencies.Get("github.com/foo/bar/lib")
+
+	// the mirror serves the same repository
+	to := *deps.Parse(dir, "github.com/mirror/bar/lib")
+	to.Version = ""
+	i.Git = localGit{remote: to.Source.GitSource.Remote(), dir: repo}
+	res, err := i.Move(ctx, "github.com/foo/bar/lib", to)
+	require.NoError(t, err)
+	assert.Equal(t, "github.com/foo/bar/lib", res.From.Name())
+	assert.Equal(t, "v1", res.To.Version)
+
+	jf, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo", "github.com/mirror/bar/lib"}, jf.Dependencies.Keys())
+
+	// the same commit, from the new location
+	after, ok := res.Locked.Get("github.com/mirror/bar/lib")
+	require.True(t, ok)
+	assert.Equal(t, before.Version, after.Version)
+	assert.Equal(t, before.Sum, after.Sum)
+	assert.NoDirExists(t, filepath.Join(dir, "vendor", "github.com", "foo"))
+	assert.DirExists(t, filepath.Join(dir, "vendor", "github.com", "mirror", "bar", "lib"))
+
+	_, err = i.Move(ctx, "github.com/foo/bar/lib", to)
+	assert.EqualError(t, err, "not a direct dependency: github.com/foo/bar/lib")
+	_, err = i.Move(ctx, "foo", to)
+	assert.EqualError(t, err, "github.com/mirror/bar/lib is already a dependency")
+}
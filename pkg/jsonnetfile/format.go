@@ -0,0 +1,400 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonnetfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	v2 "github.com/trevorackerman/jsonnet-bundler/spec/v2"
+)
+
+// Format is the syntax a jsonnetfile is written in
+type Format string
+
+const (
+	FormatJSON    Format = "json"
+	FormatYAML    Format = "yaml"
+	FormatJsonnet Format = "jsonnet"
+)
+
+// Alternative names of File, for the other formats. Lock files are always
+// JSON.
+const (
+	FileYAML    = "jsonnetfile.yaml"
+	FileJsonnet = "jsonnetfile.jsonnet"
+)
+
+// Files lists the names a jsonnetfile may have, in order of precedence
+var Files = []string{File, FileYAML, FileJsonnet}
+
+// ErrNotWritable is returned when trying to change a jsonnetfile written in
+// Jsonnet, as the result of an evaluation cannot be turned back into code
+var ErrNotWritable = errors.New("jsonnetfile.jsonnet cannot be changed automatically, edit it by hand")
+
+// FormatOf returns the format of the jsonnetfile at path, judging by its
+// extension. Anything unknown is JSON.
+func FormatOf(path string) Format {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".jsonnet":
+		return FormatJsonnet
+	default:
+		return FormatJSON
+	}
+}
+
+// Find returns the path of the jsonnetfile in dir, whatever its format. If
+// there are several, the first one of Files wins, so packages can ship a
+// generated jsonnetfile.json for older versions of jb. If there is none, the
+// path of a jsonnetfile.json is returned, so loading it fails with an error
+// satisfying os.IsNotExist.
+func Find(dir string) (string, error) {
+	for _, name := range Files {
+		p := filepath.Join(dir, name)
+		exists, err := Exists(p)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return p, nil
+		}
+	}
+	return filepath.Join(dir, File), nil
+}
+
+// EvalTimeout limits how long the evaluation of a jsonnetfile.jsonnet may take
+var EvalTimeout = 10 * time.Second
+
+// ReadJSON reads the jsonnetfile at path, converting it to JSON. Jsonnet
+// files are evaluated, see evaluate.
+func ReadJSON(path string) ([]byte, error) {
+	switch FormatOf(path) {
+	case FormatYAML:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return yamlToJSON(data)
+	case FormatJsonnet:
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		out, err := evaluate(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "evaluating %s", path)
+		}
+		return []byte(out), nil
+	default:
+		return ioutil.ReadFile(path)
+	}
+}
+
+// evaluate evaluates the Jsonnet file at path. Vendored packages are not
+// trusted, so imports are confined to the directory of path and the
+// evaluation is aborted after EvalTimeout.
+func evaluate(path string) (string, error) {
+	root, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	vm := jsonnet.MakeVM()
+	vm.Importer(&packageImporter{root: root, entry: path})
+
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := vm.EvaluateFile(path)
+		done <- result{out, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-time.After(EvalTimeout):
+		// the evaluation cannot be interrupted and keeps running until jb exits
+		return "", errors.Errorf("evaluation took longer than %s", EvalTimeout)
+	}
+}
+
+// packageImporter resolves imports relative to the importing file, like
+// jsonnet.FileImporter without library paths, but only within root
+type packageImporter struct {
+	root string
+	// entry is the file evaluated, which is imported first
+	entry string
+	files jsonnet.FileImporter
+}
+
+func (p *packageImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if importedFrom == "" && importedPath == p.entry {
+		return p.files.Import(importedFrom, importedPath)
+	}
+	if filepath.IsAbs(importedPath) || dotDot(importedPath) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %q: only files of the same package may be imported", importedPath)
+	}
+
+	// symlinks may point anywhere
+	resolved, err := filepath.EvalSymlinks(filepath.Join(filepath.Dir(importedFrom), importedPath))
+	if err == nil && !inside(p.root, resolved) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %q: only files of the same package may be imported", importedPath)
+	}
+
+	return p.files.Import(importedFrom, importedPath)
+}
+
+// dotDot reports whether path has a .. element
+func dotDot(path string) bool {
+	for _, elem := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == filepath.Separator }) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// inside reports whether p is root or below it
+func inside(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal yaml")
+	}
+	if v == nil {
+		// an empty document
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// Write stores f at path, in the format indicated by its extension. When
+// overwriting a YAML file, the comments of all keys and dependencies that are
+// still present are kept. A version 2 file stays at version 2, keeping its
+// package metadata. Jsonnet files cannot be written, see ErrNotWritable.
+func Write(path string, f v1.JsonnetFile) error {
+	format := FormatOf(path)
+	if format == FormatJsonnet {
+		return ErrNotWritable
+	}
+
+	prev, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var v interface{} = f
+	mv2, ok, err := upgrade(f, prev, format)
+	if err != nil {
+		return errors.Wrapf(err, "keeping %s at version %d", filepath.Base(path), v2.Version)
+	}
+	if ok {
+		v = mv2
+	}
+
+	var data []byte
+	switch format {
+	case FormatYAML:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "encoding json")
+		}
+		if data, err = marshalYAML(b, prev); err != nil {
+			return err
+		}
+	default:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "encoding json")
+		}
+		data = append(b, '\n')
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// upgrade converts f to version 2 if prev, the current contents of the file,
+// is at version 2, taking over its package metadata. An error is returned if
+// f cannot be expressed in version 2, instead of silently downgrading the file.
+func upgrade(f v1.JsonnetFile, prev []byte, format Format) (v2.JsonnetFile, bool, error) {
+	if format == FormatYAML && len(prev) > 0 {
+		b, err := yamlToJSON(prev)
+		if err != nil {
+			return v2.JsonnetFile{}, false, nil
+		}
+		prev = b
+	}
+
+	var old struct {
+		Version uint        `json:"version"`
+		Package *v2.Package `json:"package"`
+	}
+	if len(prev) == 0 || json.Unmarshal(prev, &old) != nil || old.Version != v2.Version {
+		return v2.JsonnetFile{}, false, nil
+	}
+
+	mv2, err := v2.FromV1(f)
+	if err != nil {
+		return v2.JsonnetFile{}, false, err
+	}
+	mv2.Package = old.Package
+	return mv2, true, nil
+}
+
+// marshalYAML converts the json document b to YAML, taking over the comments
+// of prev
+func marshalYAML(b []byte, prev []byte) ([]byte, error) {
+	// JSON is YAML, only in flow style
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+
+	if len(prev) > 0 {
+		var old yaml.Node
+		if err := yaml.Unmarshal(prev, &old); err == nil {
+			copyComments(&old, &doc)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle resets the style of all nodes, so they are encoded in block
+// style without quotes where possible
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// copyComments moves the comments of old to the matching nodes of n. Mapping
+// values are matched by key, dependencies by name and other sequence items by
+// position.
+func copyComments(old, n *yaml.Node) {
+	n.HeadComment = old.HeadComment
+	n.LineComment = old.LineComment
+	n.FootComment = old.FootComment
+
+	if old.Kind != n.Kind {
+		return
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(old.Content) > 0 && len(n.Content) > 0 {
+			copyComments(old.Content[0], n.Content[0])
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			for j := 0; j+1 < len(old.Content); j += 2 {
+				if old.Content[j].Value != n.Content[i].Value {
+					continue
+				}
+				copyComments(old.Content[j], n.Content[i])
+				copyComments(old.Content[j+1], n.Content[i+1])
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			if o := matchItem(old.Content, item, i); o != nil {
+				copyComments(o, item)
+			}
+		}
+	}
+}
+
+// matchItem returns the dependency of items with the same name as n, or the
+// item at position i if n is no dependency
+func matchItem(items []*yaml.Node, n *yaml.Node, i int) *yaml.Node {
+	name, ok := depName(n)
+	if !ok {
+		if i < len(items) {
+			return items[i]
+		}
+		return nil
+	}
+
+	for _, item := range items {
+		if other, ok := depName(item); ok && other == name {
+			return item
+		}
+	}
+	return nil
+}
+
+// depName returns the name of the dependency n, if it is one
+func depName(n *yaml.Node) (string, bool) {
+	if field(n, "source") == nil {
+		return "", false
+	}
+
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return "", false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	var d deps.Dependency
+	if err := json.Unmarshal(b, &d); err != nil {
+		return "", false
+	}
+	return d.Name(), d.Name() != ""
+}
+
+// field returns the value of key in the mapping n, if any
+func field(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonnetfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+const v1YAML = `version: 1
+dependencies:
+  # dashboards
+  - source:
+      git:
+        remote: https://github.com/grafana/jsonnet-libs
+        subdir: grafana-builder
+    version: 54865853ebc1f901964e25a2e7a0e4d2cb6b9648
+    sum: ELsYwK+kGdzX1mee2Yy+/b2mdO4Y503BOCDkFzwmGbE=
+  - name: prometheus
+    source:
+      git:
+        remote: https://github.com/prometheus/prometheus
+        subdir: documentation/prometheus-mixin
+    version: 7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684 # pinned
+    sum: bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY=
+legacyImports: false
+`
+
+const v1Jsonnet = `
+local git(remote, subdir, version, sum) = {
+  source: { git: { remote: remote, subdir: subdir } },
+  version: version,
+  sum: sum,
+};
+
+{
+  version: 1,
+  dependencies: [
+    git('https://github.com/grafana/jsonnet-libs', 'grafana-builder',
+        '54865853ebc1f901964e25a2e7a0e4d2cb6b9648',
+        'ELsYwK+kGdzX1mee2Yy+/b2mdO4Y503BOCDkFzwmGbE='),
+    git('https://github.com/prometheus/prometheus', 'documentation/prometheus-mixin',
+        (import 'versions.libsonnet').prometheus,
+        'bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY=') + { name: 'prometheus' },
+  ],
+  legacyImports: false,
+}
+`
+
+func TestLoadFormats(t *testing.T) {
+	tests := []struct {
+		Name string
+		File string
+		Data string
+	}{
+		{Name: "json", File: jsonnetfile.File, Data: v1JSON},
+		{Name: "yaml", File: jsonnetfile.FileYAML, Data: v1YAML},
+		{Name: "yml", File: "jsonnetfile.yml", Data: v1YAML},
+		{Name: "jsonnet", File: jsonnetfile.FileJsonnet, Data: v1Jsonnet},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			versions := `{ prometheus: '7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684' }`
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "versions.libsonnet"), []byte(versions), 0644))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, tc.File), []byte(tc.Data), 0644))
+
+			jf, err := jsonnetfile.Load(filepath.Join(dir, tc.File))
+			require.NoError(t, err)
+			assert.Equal(t, v1Jsonnetfile, jf)
+		})
+	}
+}
+
+func TestLoadJsonnetError(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, jsonnetfile.FileJsonnet)
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{ version: error 'nope' }`), 0644))
+
+	_, err := jsonnetfile.Load(file)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nope")
+
+	_, err = jsonnetfile.Load(filepath.Join(dir, "missing", jsonnetfile.FileJsonnet))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadJsonnetConfined(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "netrc")
+	require.NoError(t, ioutil.WriteFile(secret, []byte("machine example.com password s3cret"), 0600))
+
+	dir := filepath.Join(t.TempDir(), "pkg")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), os.ModePerm))
+	require.NoError(t, os.Symlink(secret, filepath.Join(dir, "link")))
+
+	for name, expr := range map[string]string{
+		"Absolute": `importstr '` + secret + `'`,
+		"DotDot":   `importstr '../` + filepath.Base(outside) + `/netrc'`,
+		"Nested":   `importstr 'lib/../../netrc'`,
+		"Symlink":  `importstr 'link'`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, jsonnetfile.FileJsonnet)
+			data := `{ version: 1, dependencies: [{ source: { git: { remote: 'https://example.com/' + ` + expr + ` } } }] }`
+			require.NoError(t, ioutil.WriteFile(file, []byte(data), 0644))
+
+			_, err := jsonnetfile.Load(file)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "only files of the same package may be imported")
+		})
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		defer func(old time.Duration) { jsonnetfile.EvalTimeout = old }(jsonnetfile.EvalTimeout)
+		jsonnetfile.EvalTimeout = 50 * time.Millisecond
+
+		file := filepath.Join(dir, jsonnetfile.FileJsonnet)
+		data := `local loop(n) = if n == 0 then 0 else loop(n - 1) + 0 * loop(n - 1); { version: loop(40) }`
+		require.NoError(t, ioutil.WriteFile(file, []byte(data), 0644))
+
+		_, err := jsonnetfile.Load(file)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "evaluation took longer than")
+	})
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+
+	// nothing there yet
+	p, err := jsonnetfile.Find(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, jsonnetfile.File), p)
+
+	for _, name := range []string{jsonnetfile.FileJsonnet, jsonnetfile.FileYAML, jsonnetfile.File} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(`{}`), 0644))
+
+		p, err := jsonnetfile.Find(dir)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, name), p)
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), jsonnetfile.FileYAML)
+	require.NoError(t, ioutil.WriteFile(file, []byte(v1YAML), 0644))
+
+	jf, err := jsonnetfile.Load(file)
+	require.NoError(t, err)
+
+	// drop the first dependency, add another one
+	jf.Dependencies.Delete("github.com/grafana/jsonnet-libs/grafana-builder")
+	d := deps.Parse("", "github.com/jsonnet-libs/k8s-libsonnet/1.21@main")
+	jf.Dependencies.Set(d.Name(), *d)
+
+	require.NoError(t, jsonnetfile.Write(file, jf))
+
+	got, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, `version: 1
+dependencies:
+  - source:
+      git:
+        remote: https://github.com/jsonnet-libs/k8s-libsonnet.git
+        subdir: "1.21"
+    version: main
+  - source:
+      git:
+        remote: https://github.com/prometheus/prometheus.git
+        subdir: documentation/prometheus-mixin
+    version: 7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684 # pinned
+    sum: bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY=
+    name: prometheus
+legacyImports: false
+`, string(got))
+
+	again, err := jsonnetfile.Load(file)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, jf.Dependencies.Keys(), again.Dependencies.Keys())
+}
+
+func TestWriteJsonnet(t *testing.T) {
+	file := filepath.Join(t.TempDir(), jsonnetfile.FileJsonnet)
+	err := jsonnetfile.Write(file, v1Jsonnetfile)
+	assert.Equal(t, jsonnetfile.ErrNotWritable, err)
+
+	exists, err := jsonnetfile.Exists(file)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestWriteV2(t *testing.T) {
+	file := filepath.Join(t.TempDir(), jsonnetfile.File)
+	require.NoError(t, ioutil.WriteFile(file, []byte(v2JSON), 0644))
+
+	jf, err := jsonnetfile.Load(file)
+	require.NoError(t, err)
+	jf.Dependencies.Delete("github.com/grafana/jsonnet-libs/grafana-builder")
+	require.NoError(t, jsonnetfile.Write(file, jf))
+
+	got, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "version": 2,
+  "package": {
+    "name": "mixin",
+    "jb": "v0.1.0"
+  },
+  "dependencies": [
+    {
+      "import": "prometheus",
+      "source": {
+        "git": {
+          "remote": "https://github.com/prometheus/prometheus.git",
+          "subdir": "documentation/prometheus-mixin"
+        }
+      },
+      "version": "7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684",
+      "sum": "bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY="
+    }
+  ],
+  "legacyImports": false
+}`, string(got))
+}
+
+func TestWriteV2Invalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), jsonnetfile.File)
+	require.NoError(t, ioutil.WriteFile(file, []byte(v2JSON), 0644))
+
+	jf, err := jsonnetfile.Load(file)
+	require.NoError(t, err)
+	d := *deps.Parse("", "github.com/foo/bar")
+	d.LegacyNameCompat = "../bar"
+	jf.Dependencies.Set(d.Name(), d)
+
+	// not silently written as version 1, dropping the package metadata
+	assert.Error(t, jsonnetfile.Write(file, jf))
+	got, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, v2JSON, string(got))
+}
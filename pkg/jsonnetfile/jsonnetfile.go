@@ -16,14 +16,13 @@ package jsonnetfile
 
 import (
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"os"
 
 	"github.com/pkg/errors"
 
 	v0 "github.com/trevorackerman/jsonnet-bundler/spec/v0"
 	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	v2 "github.com/trevorackerman/jsonnet-bundler/spec/v2"
 )
 
 const (
@@ -35,9 +34,14 @@ var (
 	ErrUpdateJB = errors.New("jsonnetfile version unknown, update jb")
 )
 
-// Load reads a jsonnetfile.(lock).json from disk
+// JBVersion is the version of the running jb. Version 2 jsonnetfiles
+// requiring a newer one are rejected.
+var JBVersion = "dev"
+
+// Load reads a jsonnetfile.(lock).json from disk. jsonnetfile.yaml and
+// jsonnetfile.jsonnet are read as well, see FormatOf.
 func Load(filepath string) (v1.JsonnetFile, error) {
-	bytes, err := ioutil.ReadFile(filepath)
+	bytes, err := ReadJSON(filepath)
 	if err != nil {
 		return v1.New(), err
 	}
@@ -46,7 +50,8 @@ func Load(filepath string) (v1.JsonnetFile, error) {
 }
 
 // Unmarshal creates a spec.JsonnetFile from bytes. Empty bytes
-// will create an empty spec.
+// will create an empty spec. Version 2 files are converted to version 1,
+// dropping their package metadata.
 func Unmarshal(bytes []byte) (v1.JsonnetFile, error) {
 	m := v1.New()
 
@@ -75,6 +80,15 @@ func Unmarshal(bytes []byte) (v1.JsonnetFile, error) {
 			return m, errors.Wrap(err, "failed to unmarshal v1 file")
 		}
 		return m, nil
+	case v2.Version:
+		mv2 := v2.New()
+		if err := json.Unmarshal(bytes, &mv2); err != nil {
+			return m, errors.Wrap(err, "failed to unmarshal v2 file")
+		}
+		if mv2.Package != nil && mv2.Package.Requires(JBVersion) {
+			return m, errors.Errorf("jsonnetfile requires jb %s or newer, update jb", mv2.Package.JB)
+		}
+		return mv2.V1(), nil
 	default:
 		return m, ErrUpdateJB
 	}
@@ -82,16 +96,7 @@ func Unmarshal(bytes []byte) (v1.JsonnetFile, error) {
 
 // Exists returns whether the file at the given path exists
 func Exists(path string) (bool, error) {
-	fileInfo, err := os.Stat(path)
-
-	if err != nil {
-		fmt.Println("Error checking file", path, ":", err)
-	}
-
-	if fileInfo != nil {
-		fmt.Println("Got back file info for", path, ":", fileInfo)
-	}
-
+	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		return false, nil
 	}
@@ -122,6 +122,38 @@ const v1JSON = `{
   "legacyImports": false
 }`
 
+const v2JSON = `{
+  "version": 2,
+  "package": {
+    "name": "mixin",
+    "jb": "v0.1.0"
+  },
+  "dependencies": [
+    {
+      "source": {
+        "git": {
+          "remote": "https://github.com/grafana/jsonnet-libs",
+          "subdir": "grafana-builder"
+        }
+      },
+      "version": "54865853ebc1f901964e25a2e7a0e4d2cb6b9648",
+      "sum": "ELsYwK+kGdzX1mee2Yy+/b2mdO4Y503BOCDkFzwmGbE="
+    },
+    {
+      "import": "prometheus",
+      "source": {
+        "git": {
+          "remote": "https://github.com/prometheus/prometheus",
+          "subdir": "documentation/prometheus-mixin"
+        }
+      },
+      "version": "7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684",
+      "sum": "bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY="
+    }
+  ],
+  "legacyImports": false
+}`
+
 var v1Jsonnetfile = func() v1.JsonnetFile {
 	dep := v1.JsonnetFile{
 		Dependencies:  deps.NewOrdered(),
@@ -176,6 +208,11 @@ func TestVersions(t *testing.T) {
 			JSON:        v1JSON,
 			Jsonnetfile: v1Jsonnetfile,
 		},
+		{
+			Name:        "v2",
+			JSON:        v2JSON,
+			Jsonnetfile: v1Jsonnetfile,
+		},
 		{
 			Name:        "v100",
 			JSON:        `{"version": 100}`,
@@ -255,3 +292,18 @@ func TestFileExists(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestUnmarshalV2(t *testing.T) {
+	defer func(v string) { jsonnetfile.JBVersion = v }(jsonnetfile.JBVersion)
+
+	jsonnetfile.JBVersion = "v0.0.9"
+	_, err := jsonnetfile.Unmarshal([]byte(v2JSON))
+	assert.EqualError(t, err, "jsonnetfile requires jb v0.1.0 or newer, update jb")
+
+	jsonnetfile.JBVersion = "v0.1.0"
+	_, err = jsonnetfile.Unmarshal([]byte(v2JSON))
+	assert.NoError(t, err)
+
+	_, err = jsonnetfile.Unmarshal([]byte(`{"version": 2, "dependencies": [], "legacyImports": false, "extra": true}`))
+	assert.EqualError(t, err, `failed to unmarshal v2 file: json: unknown field "extra"`)
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonnetfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// WorkspaceFile lists the member projects of a workspace, which share a
+// single lock and vendor directory next to it
+const WorkspaceFile = "jsonnetworkspace.json"
+
+// WorkspaceVersion is the current version of the WorkspaceFile
+const WorkspaceVersion uint = 1
+
+// Workspace is the content of a WorkspaceFile
+type Workspace struct {
+	Version uint `json:"version"`
+	// Members are the directories of the member projects, as slash separated
+	// paths relative to the WorkspaceFile. Glob patterns are allowed.
+	Members []string `json:"members"`
+}
+
+// LoadWorkspace reads the WorkspaceFile at path. Unknown fields are errors.
+func LoadWorkspace(path string) (Workspace, error) {
+	var ws Workspace
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ws, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ws); err != nil {
+		return ws, errors.Wrapf(err, "failed to unmarshal %s", WorkspaceFile)
+	}
+	if ws.Version > WorkspaceVersion {
+		return ws, ErrUpdateJB
+	}
+	return ws, nil
+}
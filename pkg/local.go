@@ -19,7 +19,6 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/fatih/color"
 	"github.com/pkg/errors"
 
 	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
@@ -35,13 +34,18 @@ func NewLocalPackage(source *deps.Local) Interface {
 	}
 }
 
+// Install links the local directory into dir. Relative directories are
+// resolved against the current working directory.
 func (p *LocalPackage) Install(ctx context.Context, name, dir, version string) (lockVersion string, err error) {
-	wd, err := os.Getwd()
-	if err != nil {
-		return "", errors.Wrap(err, "failed to get current working directory")
+	oldname := p.Source.Directory
+	if !filepath.IsAbs(oldname) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get current working directory")
+		}
+		oldname = filepath.Join(wd, oldname)
 	}
 
-	oldname := filepath.Join(wd, p.Source.Directory)
 	newname := filepath.Join(dir, name)
 	linkname, err := filepath.Rel(dir, oldname)
 
@@ -64,7 +68,7 @@ func (p *LocalPackage) Install(ctx context.Context, name, dir, version string) (
 		return "", errors.Wrap(err, "failed to create symlink for local dependency")
 	}
 
-	color.Magenta("LOCAL %s -> %s", name, oldname)
+	report(ctx, Event{Type: EventLink, Package: name, Path: newname, Target: oldname})
 
 	return "", nil
 }
@@ -0,0 +1,141 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// Level is the severity of a log message
+type Level int
+
+const (
+	// LevelDebug is for details like every file being hashed or extracted
+	LevelDebug Level = iota
+	// LevelInfo is for the individual steps, like git commands and requests
+	LevelInfo
+	// LevelWarn is for unexpected conditions that do not abort the operation
+	LevelWarn
+	// LevelError is for failures
+	LevelError
+)
+
+// String returns the lowercase name of the level
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// Logger receives the diagnostic output of the library. Progress meant for
+// users and tools is reported as Events instead.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+
+	// Writer returns where output of subprocesses (e.g. git) logged at the
+	// given level should be written to. It is nil if that level is disabled.
+	Writer(level Level) io.Writer
+}
+
+// NewLogger returns a Logger that writes all messages of at least the given
+// level to w, one per line.
+func NewLogger(w io.Writer, level Level) Logger {
+	return &writerLogger{w: w, level: level}
+}
+
+type writerLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+func (l *writerLogger) logf(level Level, c *color.Color, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	msg := Redact(fmt.Sprintf(format, args...))
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c != nil {
+		c.Fprint(l.w, msg)
+		return
+	}
+	fmt.Fprint(l.w, msg)
+}
+
+func (l *writerLogger) Debugf(format string, args ...interface{}) {
+	l.logf(LevelDebug, nil, format, args...)
+}
+
+func (l *writerLogger) Infof(format string, args ...interface{}) {
+	l.logf(LevelInfo, nil, format, args...)
+}
+
+func (l *writerLogger) Warnf(format string, args ...interface{}) {
+	l.logf(LevelWarn, color.New(color.FgYellow), "WARN: "+format, args...)
+}
+
+func (l *writerLogger) Writer(level Level) io.Writer {
+	if level < l.level {
+		return nil
+	}
+	return redactWriter{w: l.w}
+}
+
+// discard is the Logger used if none was configured
+type discard struct{}
+
+func (discard) Debugf(string, ...interface{}) {}
+func (discard) Infof(string, ...interface{})  {}
+func (discard) Warnf(string, ...interface{})  {}
+func (discard) Writer(Level) io.Writer        { return nil }
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx that causes all operations using it to log
+// to l. Without a Logger, nothing is logged.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logger returns the Logger of ctx
+func logger(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return discard{}
+}
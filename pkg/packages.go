@@ -16,15 +16,11 @@ package pkg
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/fatih/color"
 	"github.com/pkg/errors"
 
 	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
@@ -36,7 +32,25 @@ var (
 	VersionMismatch = errors.New("multiple colliding versions specified")
 )
 
-// Ensure receives all direct packages, the directory to vendor into and all known locks.
+// ChecksumError is returned if the files of a package do not match the sum
+// recorded in the lock
+type ChecksumError struct {
+	Package  string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s. Expected %s but got %s", e.Package, e.Expected, e.Actual)
+}
+
+// Ensure is a shorthand for Installer.Ensure using the default settings
+func Ensure(ctx context.Context, direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered) (*deps.Ordered, error) {
+	i := Installer{VendorDir: vendorDir}
+	return i.Ensure(ctx, direct, oldLocks)
+}
+
+// Ensure receives all direct packages and all known locks.
 // It then makes sure all direct and nested dependencies are present in vendor at the correct version:
 //
 // If the package is locked and the files in vendor match the sha256 checksum,
@@ -49,13 +63,48 @@ var (
 //
 // Finally, all unknown files and directories are removed from vendor/
 // The full list of locked depedencies is returned
-func Ensure(direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered) (*deps.Ordered, error) {
+//
+// Replaced packages are kept until all downloads and checksums succeeded. If
+// any of them fails, or ctx is cancelled, vendor/ is restored to its previous
+// state.
+func (i *Installer) Ensure(ctx context.Context, direct v1.JsonnetFile, oldLocks *deps.Ordered) (*deps.Ordered, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return i.ensureVendor(i.context(ctx), direct, oldLocks)
+}
+
+func (i *Installer) ensureVendor(ctx context.Context, direct v1.JsonnetFile, oldLocks *deps.Ordered) (*deps.Ordered, error) {
+	vendorDir := i.vendorDir()
+
+	st, err := newStaging(ctx, vendorDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// packages of the same repository are retrieved once
+	cache, err := newFetchCache(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withFetchCache(ctx, cache)
+
 	// ensure all required files are in vendor
 	// This is the actual installation
-	locks, err := ensure(direct.Dependencies, vendorDir, "", oldLocks)
+	locks, err := i.ensure(ctx, st, direct.Dependencies, vendorDir, "", oldLocks)
 	if err != nil {
+		// put back what was there before
+		if rbErr := st.rollback(); rbErr != nil {
+			report(ctx, Event{Type: EventWarning, Path: vendorDir, Message: rbErr.Error()})
+		}
 		return nil, err
 	}
+	if err := st.commit(); err != nil {
+		return nil, errors.Wrap(err, "removing temporary files")
+	}
 
 	// remove unchanged legacyNames
 	CleanLegacyName(locks)
@@ -85,7 +134,7 @@ func Ensure(direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered) (*d
 				return nil, err
 			}
 			if !strings.HasPrefix(name, ".tmp") {
-				color.Magenta("CLEAN %s", dir)
+				report(ctx, Event{Type: EventClean, Path: dir})
 			}
 		}
 	}
@@ -97,7 +146,7 @@ func Ensure(direct v1.JsonnetFile, vendorDir string, oldLocks *deps.Ordered) (*d
 	if !direct.LegacyImports {
 		return locks, nil
 	}
-	if err := linkLegacy(vendorDir, locks); err != nil {
+	if err := linkLegacy(ctx, vendorDir, locks); err != nil {
 		return nil, err
 	}
 
@@ -118,11 +167,14 @@ func CleanLegacyName(list *deps.Ordered) {
 }
 
 func cleanLegacySymlinks(vendorDir string, locks *deps.Ordered) error {
-	// local packages need to be ignored
+	// local packages need to be ignored, symlinks inside of git packages
+	// are part of their files
 	locals := map[string]bool{}
+	packages := map[string]bool{}
 	for _, k := range locks.Keys() {
 		d, _ := locks.Get(k)
 		if d.Source.LocalSource == nil {
+			packages[filepath.Join(vendorDir, d.Name())] = true
 			continue
 		}
 
@@ -134,6 +186,9 @@ func cleanLegacySymlinks(vendorDir string, locks *deps.Ordered) error {
 		if locals[path] {
 			return nil
 		}
+		if packages[path] && i.IsDir() {
+			return filepath.SkipDir
+		}
 
 		if i.Mode()&os.ModeSymlink != 0 {
 			if err := os.Remove(path); err != nil {
@@ -144,7 +199,7 @@ func cleanLegacySymlinks(vendorDir string, locks *deps.Ordered) error {
 	})
 }
 
-func linkLegacy(vendorDir string, locks *deps.Ordered) error {
+func linkLegacy(ctx context.Context, vendorDir string, locks *deps.Ordered) error {
 	// create only the ones we want
 	for _, k := range locks.Keys() {
 		d, _ := locks.Get(k)
@@ -156,9 +211,9 @@ func linkLegacy(vendorDir string, locks *deps.Ordered) error {
 		legacyName := filepath.Join(vendorDir, d.LegacyName())
 		pkgName := d.Name()
 
-		taken, err := checkLegacyNameTaken(legacyName, pkgName)
+		taken, err := checkLegacyNameTaken(ctx, legacyName, pkgName)
 		if err != nil {
-			fmt.Println(err)
+			report(ctx, Event{Type: EventWarning, Package: pkgName, Path: legacyName, Message: err.Error()})
 			continue
 		}
 		if taken {
@@ -172,11 +227,12 @@ func linkLegacy(vendorDir string, locks *deps.Ordered) error {
 		); err != nil {
 			return err
 		}
+		report(ctx, Event{Type: EventLink, Package: pkgName, Path: legacyName, Target: pkgName})
 	}
 	return nil
 }
 
-func checkLegacyNameTaken(legacyName string, pkgName string) (bool, error) {
+func checkLegacyNameTaken(ctx context.Context, legacyName string, pkgName string) (bool, error) {
 	fi, err := os.Lstat(legacyName)
 	if err != nil {
 		// does not exist: not taken
@@ -193,12 +249,22 @@ func checkLegacyNameTaken(legacyName string, pkgName string) (bool, error) {
 		if err != nil {
 			return false, err
 		}
-		color.Yellow("WARN: cannot link '%s' to '%s', because package '%s' already uses that name. The absolute import still works\n", pkgName, legacyName, s)
+		report(ctx, Event{
+			Type:    EventWarning,
+			Package: pkgName,
+			Path:    legacyName,
+			Message: fmt.Sprintf("cannot link '%s' to '%s', because package '%s' already uses that name. The absolute import still works", pkgName, legacyName, s),
+		})
 		return true, nil
 	}
 
 	// sth else
-	color.Yellow("WARN: cannot link '%s' to '%s', because the file/directory already exists. The absolute import still works.\n", pkgName, legacyName)
+	report(ctx, Event{
+		Type:    EventWarning,
+		Package: pkgName,
+		Path:    legacyName,
+		Message: fmt.Sprintf("cannot link '%s' to '%s', because the file/directory already exists. The absolute import still works", pkgName, legacyName),
+	})
 	return true, nil
 }
 
@@ -214,37 +280,81 @@ func known(deps *deps.Ordered, p string) bool {
 	return false
 }
 
-func ensure(direct *deps.Ordered, vendorDir, pathToParentModule string, locks *deps.Ordered) (*deps.Ordered, error) {
-	fmt.Println("ensuring", len(direct.Keys()), "direct dependencies in", vendorDir, "for parent module", pathToParentModule)
+func (i *Installer) ensure(ctx context.Context, st *staging, direct *deps.Ordered, vendorDir, pathToParentModule string, locks *deps.Ordered) (*deps.Ordered, error) {
 	deps := deps.NewOrdered()
 
 	for _, k := range direct.Keys() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		d, _ := direct.Get(k)
 		l, present := locks.Get(d.Name())
 
+		// locked with other filters: the files differ from the lock
+		filtered := present && !d.SameFilter(l)
+
 		// already locked and the integrity is intact
 		if present {
 			d.Version = l.Version
 
-			if check(l, vendorDir) {
+			if !filtered && check(ctx, l, vendorDir) {
+				// verified using the legacy sum: upgrade the lock entry
+				if isLegacySum(l.Sum) && l.Pruned == nil {
+					sum, err := sumDir(ctx, filepath.Join(vendorDir, l.Name()))
+					if err != nil {
+						return nil, errors.Wrapf(err, "hashing %s", l.Name())
+					}
+					logger(ctx).Infof("migrating sum of %s to %s", l.Name(), sum)
+					l.Sum = sum
+					locks.Set(l.Name(), l)
+				}
+
+				report(ctx, Event{Type: EventResolve, Package: d.Name(), Version: l.Version, Sum: l.Sum})
 				deps.Set(d.Name(), l)
 				continue
 			}
 		}
 		expectedSum := l.Sum
+		if filtered {
+			logger(ctx).Infof("filters of %s changed, vendoring it again", d.Name())
+			expectedSum = ""
+		}
 
-		// either not present or not intact: download again
+		// either not present or not intact: download again, keeping the old
+		// files around until the run succeeded
 		dir := filepath.Join(vendorDir, d.Name())
-		os.RemoveAll(dir)
+		if err := st.replace(dir); err != nil {
+			return nil, err
+		}
 
-		fmt.Println("downloading", d.Name(), "to", vendorDir, "at version", d.Version)
-		locked, err := download(d, vendorDir, pathToParentModule)
+		report(ctx, Event{Type: EventDownloadStart, Package: d.Name(), Version: d.Version, Path: dir})
+		locked, err := i.download(ctx, d, vendorDir, pathToParentModule)
 		if err != nil {
+			report(ctx, Event{Type: EventDownloadFinish, Package: d.Name(), Version: d.Version, Error: err.Error()})
 			return nil, errors.Wrap(err, "downloading")
 		}
-		if expectedSum != "" && locked.Sum != expectedSum {
-			return nil, fmt.Errorf("checksum mismatch for %s. Expected %s but got %s", d.Name(), expectedSum, locked.Sum)
+		report(ctx, Event{Type: EventDownloadFinish, Package: d.Name(), Version: locked.Version, Sum: locked.Sum, Path: dir})
+
+		if expectedSum != "" {
+			// old locks may still hold a legacy sum
+			actual, err := sumLike(ctx, dir, expectedSum)
+			if err != nil {
+				return nil, errors.Wrapf(err, "hashing %s", d.Name())
+			}
+			if actual != expectedSum {
+				return nil, &ChecksumError{Package: d.Name(), Expected: expectedSum, Actual: actual}
+			}
+		}
+
+		// the same version was pruned before, prune it again
+		if present && l.Pruned != nil && l.Version == locked.Version && !filtered {
+			if err := reprune(ctx, dir, l, locks); err != nil {
+				return nil, err
+			}
+			locked.Pruned = l.Pruned
 		}
+		report(ctx, Event{Type: EventResolve, Package: d.Name(), Version: locked.Version, Sum: locked.Sum})
 		deps.Set(d.Name(), *locked)
 		// we settled on a new version, add it to the locks for recursion
 		locks.Set(d.Name(), *locked)
@@ -261,14 +371,17 @@ func ensure(direct *deps.Ordered, vendorDir, pathToParentModule string, locks *d
 		// Check if p is a file or a directory
 		info, err := os.Stat(p)
 		if err != nil {
-			fmt.Printf("error stating path %s: %v\n", p, err)
+			logger(ctx).Warnf("error stating path %s: %v", p, err)
 		} else if !info.IsDir() {
 			continue
 		}
 
-		jf := filepath.Join(vendorDir, d.Name(), jsonnetfile.File)
+		jf, err := jsonnetfile.Find(filepath.Join(vendorDir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
 
-		fmt.Println("loading jsonnetfile", jf)
+		logger(ctx).Debugf("loading jsonnetfile %s", jf)
 		exists, err := jsonnetfile.Exists(jf)
 		if err != nil {
 			return nil, errors.Wrapf(err, "checking for jsonnetfile %s", jf)
@@ -290,7 +403,7 @@ func ensure(direct *deps.Ordered, vendorDir, pathToParentModule string, locks *d
 			return nil, err
 		}
 
-		nested, err := ensure(f.Dependencies, vendorDir, absolutePath, locks)
+		nested, err := i.ensure(ctx, st, f.Dependencies, vendorDir, absolutePath, locks)
 		if err != nil {
 			return nil, err
 		}
@@ -308,25 +421,28 @@ func ensure(direct *deps.Ordered, vendorDir, pathToParentModule string, locks *d
 
 // download retrieves a package from a remote upstream. The checksum of the
 // files is generated afterwards.
-func download(d deps.Dependency, vendorDir, pathToParentModule string) (*deps.Dependency, error) {
-	fmt.Println("downloading", d.Name(), "to", vendorDir)
+func (i *Installer) download(ctx context.Context, d deps.Dependency, vendorDir, pathToParentModule string) (*deps.Dependency, error) {
 	var p Interface
 	switch {
 	case d.Source.GitSource != nil:
-		p = NewGitPackage(d.Source.GitSource)
+		p = i.gitPackage(d.Source.GitSource)
 	case d.Source.LocalSource != nil:
-		wd, err := os.Getwd()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get current working directory: %w", err)
-		}
-
 		// Resolve the relative path to the parent module. When a local
 		// dependency tree is resolved recursively, nested local dependencies
 		// with relative paths must be evaluated relative to their referencing
 		// jsonnetfile, rather than relative to the top-level jsonnetfile.
-		modulePath, err := filepath.Rel(wd, filepath.Join(pathToParentModule, d.Source.LocalSource.Directory))
-		if err != nil {
-			modulePath = d.Source.LocalSource.Directory
+		parent := pathToParentModule
+		if parent == "" {
+			parent = i.rootDir()
+		}
+
+		modulePath := d.Source.LocalSource.Directory
+		if !filepath.IsAbs(modulePath) {
+			abs, err := filepath.Abs(filepath.Join(parent, modulePath))
+			if err != nil {
+				return nil, err
+			}
+			modulePath = abs
 		}
 
 		p = NewLocalPackage(&deps.Local{Directory: modulePath})
@@ -335,29 +451,42 @@ func download(d deps.Dependency, vendorDir, pathToParentModule string) (*deps.De
 	if p == nil {
 		return nil, errors.New("either git or local source is required")
 	}
+	if err := d.CheckFilter(); err != nil {
+		return nil, err
+	}
 
-	version, err := p.Install(context.TODO(), d.Name(), vendorDir, d.Version)
+	version, err := p.Install(ctx, d.Name(), vendorDir, d.Version)
 	if err != nil {
 		return nil, err
 	}
 
 	var sum string
 	if d.Source.LocalSource == nil {
-		fmt.Println("hashing", filepath.Join(vendorDir, d.Name()), "which does not have a local source")
-		sum = hashDir(filepath.Join(vendorDir, d.Name()))
+		if err := filter(ctx, filepath.Join(vendorDir, d.Name()), d); err != nil {
+			return nil, err
+		}
+
+		logger(ctx).Debugf("hashing %s", filepath.Join(vendorDir, d.Name()))
+		sum, err = sumDir(ctx, filepath.Join(vendorDir, d.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "hashing")
+		}
 	}
 
 	d.Version = version
 	d.Sum = sum
+	if g, ok := p.(*GitPackage); ok {
+		d.Method = g.Method
+	}
 	return &d, nil
 }
 
 // check returns whether the files present at the vendor/ folder match the
-// sha256 sum of the package. local-directory dependencies are not checked as
+// sum of the package, which may be of either format, or the pruned sum if the
+// package was pruned. local-directory dependencies are not checked as
 // their purpose is to change during development where integrity checking would
 // be a hindrance.
-func check(d deps.Dependency, vendorDir string) bool {
-	fmt.Println("checking", d.Name(), "in", vendorDir)
+func check(ctx context.Context, d deps.Dependency, vendorDir string) bool {
 	// assume a local dependency is intact as long as it exists
 	if d.Source.LocalSource != nil {
 		x, err := jsonnetfile.Exists(filepath.Join(vendorDir, d.Name()))
@@ -372,43 +501,14 @@ func check(d deps.Dependency, vendorDir string) bool {
 		return false
 	}
 
+	expected := lockedSum(d)
 	dir := filepath.Join(vendorDir, d.Name())
-	fmt.Println("check gonna hashDir", dir)
-	sum := hashDir(dir)
-	return d.Sum == sum
-}
-
-// hashDir computes the checksum of a directory by concatenating all files and
-// hashing this data using sha256. This can be memory heavy with lots of data,
-// but jsonnet files should be fairly small
-func hashDir(dir string) string {
-	fmt.Println("hasdir hashing", dir)
-	hasher := sha256.New()
-
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		fmt.Println("hashdir opening", path)
-		f, err := os.Open(path)
-		if err != nil {
-			fmt.Println("error opening", path, ":", err)
-			return err
-		}
-		defer f.Close()
-		fmt.Println("hashdir opened", path)
-
-		if _, err := io.Copy(hasher, f); err != nil {
-			return err
-		}
-
-		return nil
-	})
-
-	return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+	sum, err := sumLike(ctx, dir, expected)
+	if err != nil {
+		logger(ctx).Debugf("hashing %s: %s", dir, err)
+		return false
+	}
+	ok := expected == sum
+	report(ctx, Event{Type: EventChecksum, Package: d.Name(), Version: d.Version, Sum: sum, Path: dir, OK: &ok})
+	return ok
 }
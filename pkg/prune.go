@@ -0,0 +1,336 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// PruneOptions customize Installer.Prune
+type PruneOptions struct {
+	// DryRun only lists the files that would be removed
+	DryRun bool
+}
+
+// PruneResult lists the outcome of Installer.Prune
+type PruneResult struct {
+	// Removed lists the removed files of each package, as slash separated
+	// paths relative to the package
+	Removed map[string][]string
+}
+
+// Prune removes all files of vendored git packages except the ones listed in
+// keep, which is keyed by package name and holds slash separated paths
+// relative to the package. Packages missing from keep are left alone. The
+// jsonnetfile and license files of a package are always kept.
+//
+// The remaining files and their sum are recorded in the lock, so the package
+// can still be verified and is pruned the same way when installed again.
+func (i *Installer) Prune(ctx context.Context, keep map[string][]string, opts PruneOptions) (*PruneResult, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ctx = i.context(ctx)
+	vendorDir := i.vendorDir()
+	lockPath := i.LockPath()
+
+	lockFile, err := jsonnetfile.Load(lockPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+	locks := lockFile.Dependencies
+
+	res := &PruneResult{Removed: map[string][]string{}}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		files, ok := keep[d.Name()]
+		if !ok || d.Source.GitSource == nil {
+			continue
+		}
+
+		if !check(ctx, d, vendorDir) {
+			return nil, errors.Errorf("%s does not match the lock, run jb install first", d.Name())
+		}
+
+		dir := filepath.Join(vendorDir, d.Name())
+		kept, removed, err := prune(dir, files, nestedPackages(d.Name(), locks), opts.DryRun)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pruning %s", d.Name())
+		}
+		res.Removed[d.Name()] = removed
+		if opts.DryRun || (len(removed) == 0 && d.Pruned == nil) {
+			continue
+		}
+		logger(ctx).Infof("pruned %d files of %s", len(removed), d.Name())
+
+		sum, err := sumDir(ctx, dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "hashing %s", d.Name())
+		}
+		d.Pruned = &deps.Pruned{Keep: kept, Sum: sum}
+		locks.Set(d.Name(), d)
+	}
+
+	if opts.DryRun {
+		return res, nil
+	}
+	if err := writeJSONFile(lockPath, v1.JsonnetFile{Dependencies: locks}); err != nil {
+		return nil, errors.Wrap(err, "updating jsonnetfile.lock.json")
+	}
+	return res, nil
+}
+
+// Unprune drops the pruned manifests from the lock and downloads the complete
+// files of the affected packages again. If names are given, only these
+// packages are restored.
+func (i *Installer) Unprune(ctx context.Context, names ...string) (*Result, error) {
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := i.projectDir()
+
+	jbfile, err := jsonnetfile.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonnetFile, err := jsonnetfile.Load(jbfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	lockFile, err := jsonnetfile.Load(i.LockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lockfile")
+	}
+
+	only := map[string]bool{}
+	for _, name := range names {
+		only[name] = true
+	}
+
+	locks := lockFile.Dependencies
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if d.Pruned != nil && (len(only) == 0 || only[d.Name()]) {
+			d.Pruned = nil
+			locks.Set(k, d)
+		}
+	}
+
+	res, err := i.ensureCollect(ctx, jsonnetFile, locks)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeJSONFile(i.LockPath(), v1.JsonnetFile{Dependencies: res.Locked}); err != nil {
+		return nil, errors.Wrap(err, "updating jsonnetfile.lock.json")
+	}
+	return res, nil
+}
+
+// reprune prunes a freshly downloaded package the way the lock entry l
+// records, verifying the result against the pruned sum
+func reprune(ctx context.Context, dir string, l deps.Dependency, locks *deps.Ordered) error {
+	_, removed, err := prune(dir, l.Pruned.Keep, nestedPackages(l.Name(), locks), false)
+	if err != nil {
+		return errors.Wrapf(err, "pruning %s", l.Name())
+	}
+	logger(ctx).Infof("pruned %d files of %s", len(removed), l.Name())
+
+	sum, err := sumDir(ctx, dir)
+	if err != nil {
+		return errors.Wrapf(err, "hashing %s", l.Name())
+	}
+	if sum != l.Pruned.Sum {
+		return &ChecksumError{Package: l.Name(), Expected: l.Pruned.Sum, Actual: sum}
+	}
+	return nil
+}
+
+// filter removes the files of the freshly downloaded package d in dir that its
+// include and exclude patterns leave out. Like with pruning, the jsonnetfile
+// and license files are always kept.
+func filter(ctx context.Context, dir string, d deps.Dependency) error {
+	if !d.Filtered() {
+		return nil
+	}
+
+	var keep []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); d.Keeps(rel) {
+			keep = append(keep, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "filtering %s", d.Name())
+	}
+
+	_, removed, err := prune(dir, keep, nil, false)
+	if err != nil {
+		return errors.Wrapf(err, "filtering %s", d.Name())
+	}
+	logger(ctx).Debugf("filtered %d files of %s", len(removed), d.Name())
+	return nil
+}
+
+// lockedSum returns the sum the vendored files of d have to match
+func lockedSum(d deps.Dependency) string {
+	if d.Pruned != nil {
+		return d.Pruned.Sum
+	}
+	return d.Sum
+}
+
+// nestedPackages returns the directories of the packages located inside of
+// the package name, relative to it
+func nestedPackages(name string, locks *deps.Ordered) []string {
+	var nested []string
+	prefix := filepath.ToSlash(name) + "/"
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+		if n := filepath.ToSlash(d.Name()); strings.HasPrefix(n, prefix) {
+			nested = append(nested, strings.TrimPrefix(n, prefix))
+		}
+	}
+	return nested
+}
+
+// alwaysKept returns whether the file at the slash separated path rel is kept
+// regardless of imports: the jsonnetfile is required to resolve nested
+// dependencies, license files have to be redistributed.
+func alwaysKept(rel string) bool {
+	for _, name := range jsonnetfile.Files {
+		if rel == name {
+			return true
+		}
+	}
+	base := strings.ToUpper(filepath.Base(rel))
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "NOTICE"} {
+		if strings.HasPrefix(base, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes all files below dir that are not listed in keep, except the
+// ones of nested packages. Symlinks are kept if a kept path goes through them.
+// Directories left empty are removed as well. It returns the kept and removed
+// files as sorted slash separated paths relative to dir.
+func prune(dir string, keep []string, nested []string, dryRun bool) (kept, removed []string, err error) {
+	keepSet := make(map[string]bool, len(keep))
+	for _, k := range keep {
+		keepSet[k] = true
+	}
+	nestedSet := make(map[string]bool, len(nested))
+	for _, n := range nested {
+		nestedSet[n] = true
+	}
+
+	var dirs []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if nestedSet[rel] {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		if keepSet[rel] || alwaysKept(rel) || (info.Mode()&os.ModeSymlink != 0 && throughLink(rel, keep)) {
+			kept = append(kept, rel)
+			return nil
+		}
+
+		removed = append(removed, rel)
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !dryRun {
+		// deepest first, so parents become empty before they are looked at
+		for j := len(dirs) - 1; j >= 0; j-- {
+			entries, err := ioutil.ReadDir(dirs[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(entries) > 0 {
+				continue
+			}
+			if err := os.Remove(dirs[j]); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	sort.Strings(kept)
+	sort.Strings(removed)
+	return kept, removed, nil
+}
+
+// throughLink returns whether any of the paths in keep resolves through the
+// symlink at link
+func throughLink(link string, keep []string) bool {
+	for _, k := range keep {
+		if strings.HasPrefix(k, link+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestPrune(t *testing.T) {
+	files := map[string]string{
+		"jsonnetfile.json":              "{}",
+		"LICENSE":                       "license",
+		"main.libsonnet":                "import 'lib/util.libsonnet'",
+		"lib/util.libsonnet":            "{}",
+		"alias":                         "->lib",
+		"docs/README.md":                "docs",
+		"examples/a/ex.jsonnet":         "{}",
+		"tests/test.jsonnet":            "{}",
+		"nested/main.libsonnet":         "{}",
+		"nested/unused.libsonnet":       "{}",
+		"examples/a/LICENSE.thirdparty": "license",
+	}
+	keep := []string{"main.libsonnet", "lib/util.libsonnet", "alias/util.libsonnet"}
+
+	t.Run("DryRun", func(t *testing.T) {
+		dir := t.TempDir()
+		testutil.WriteFiles(t, dir, files)
+		before, err := HashDir(dir)
+		require.NoError(t, err)
+
+		_, removed, err := prune(dir, keep, []string{"nested"}, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"docs/README.md", "examples/a/ex.jsonnet", "tests/test.jsonnet"}, removed)
+
+		after, err := HashDir(dir)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		dir := t.TempDir()
+		testutil.WriteFiles(t, dir, files)
+
+		kept, removed, err := prune(dir, keep, []string{"nested"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"docs/README.md", "examples/a/ex.jsonnet", "tests/test.jsonnet"}, removed)
+		assert.Equal(t, []string{"LICENSE", "alias", "examples/a/LICENSE.thirdparty", "jsonnetfile.json", "lib/util.libsonnet", "main.libsonnet"}, kept)
+
+		// emptied directories are gone, nested packages are left alone
+		assert.NoDirExists(t, filepath.Join(dir, "docs"))
+		assert.NoDirExists(t, filepath.Join(dir, "tests"))
+		assert.FileExists(t, filepath.Join(dir, "examples/a/LICENSE.thirdparty"))
+		assert.FileExists(t, filepath.Join(dir, "nested/unused.libsonnet"))
+	})
+}
+
+func TestPruneReinstall(t *testing.T) {
+	ctx := context.Background()
+	repo := gitRepo(t)
+	dir := t.TempDir()
+
+	d := *deps.Parse("", "github.com/foo/bar/lib@v1")
+	jf := v1.New()
+	jf.Dependencies.Set(d.Name(), d)
+	require.NoError(t, writeJSONFile(filepath.Join(dir, jsonnetfile.File), jf))
+
+	i := Installer{
+		ProjectDir: dir,
+		Git:        localGit{remote: d.Source.GitSource.Remote(), dir: repo},
+		HTTPClient: &http.Client{Transport: archiveTransport{dir: repo}},
+		Retry:      &RetryPolicy{},
+	}
+	_, err := i.Install(ctx, nil, InstallOptions{})
+	require.NoError(t, err)
+
+	res, err := i.Prune(ctx, map[string][]string{d.Name(): {"main.libsonnet"}}, PruneOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".gitattributes", "link.libsonnet", "version.txt"}, res.Removed[d.Name()])
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	locked, _ := lock.Dependencies.Get(d.Name())
+	require.NotNil(t, locked.Pruned)
+	assert.Equal(t, []string{"main.libsonnet"}, locked.Pruned.Keep)
+
+	verify := func() {
+		t.Helper()
+		v, err := i.Verify(ctx)
+		require.NoError(t, err)
+		assert.True(t, v.Valid(), "%+v", v)
+	}
+	verify()
+
+	// a fresh install is verified against the full sum and pruned again
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, DefaultVendorDir)))
+	_, err = i.Install(ctx, nil, InstallOptions{})
+	require.NoError(t, err)
+	verify()
+	assert.NoFileExists(t, filepath.Join(dir, DefaultVendorDir, d.Name(), "version.txt"))
+
+	// other packages are left alone
+	_, err = i.Unprune(ctx, "github.com/foo/other")
+	require.NoError(t, err)
+	verify()
+	assert.NoFileExists(t, filepath.Join(dir, DefaultVendorDir, d.Name(), "version.txt"))
+
+	// unpruning restores everything
+	_, err = i.Unprune(ctx, d.Name())
+	require.NoError(t, err)
+	verify()
+	assert.FileExists(t, filepath.Join(dir, DefaultVendorDir, d.Name(), "version.txt"))
+
+	lock, err = jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	locked, _ = lock.Dependencies.Get(d.Name())
+	assert.Nil(t, locked.Pruned)
+}
+
+func TestFilter(t *testing.T) {
+	ctx := context.Background()
+	repo := gitRepo(t)
+	dir := t.TempDir()
+
+	d := *deps.Parse("", "github.com/foo/bar/lib@v1")
+	d.Include = []string{"**/*.libsonnet"}
+	d.Exclude = []string{"link.libsonnet"}
+	jf := v1.New()
+	jf.Dependencies.Set(d.Name(), d)
+	require.NoError(t, writeJSONFile(filepath.Join(dir, jsonnetfile.File), jf))
+
+	i := Installer{
+		ProjectDir: dir,
+		Git:        localGit{remote: d.Source.GitSource.Remote(), dir: repo},
+		HTTPClient: &http.Client{Transport: archiveTransport{dir: repo}},
+		Retry:      &RetryPolicy{},
+	}
+	_, err := i.Install(ctx, nil, InstallOptions{})
+	require.NoError(t, err)
+
+	pkgDir := filepath.Join(dir, DefaultVendorDir, d.Name())
+	assert.FileExists(t, filepath.Join(pkgDir, "main.libsonnet"))
+	assert.NoFileExists(t, filepath.Join(pkgDir, "link.libsonnet"))
+	assert.NoFileExists(t, filepath.Join(pkgDir, "version.txt"))
+
+	// the sum covers the filtered files
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	locked, _ := lock.Dependencies.Get(d.Name())
+	assert.Equal(t, d.Include, locked.Include)
+	sum, err := HashDir(pkgDir)
+	require.NoError(t, err)
+	assert.Equal(t, sum, locked.Sum)
+
+	v, err := i.Verify(ctx)
+	require.NoError(t, err)
+	assert.True(t, v.Valid(), "%+v", v)
+
+	// changing the filters vendors the package again
+	d.Exclude = nil
+	jf.Dependencies.Set(d.Name(), d)
+	require.NoError(t, writeJSONFile(filepath.Join(dir, jsonnetfile.File), jf))
+	_, err = i.Install(ctx, nil, InstallOptions{})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(pkgDir, "link.libsonnet"))
+
+	lock, err = jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	relocked, _ := lock.Dependencies.Get(d.Name())
+	assert.Equal(t, locked.Version, relocked.Version)
+	assert.NotEqual(t, locked.Sum, relocked.Sum)
+	assert.Empty(t, relocked.Exclude)
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy describes how failed network operations (archive downloads, git
+// fetches) are retried.
+type RetryPolicy struct {
+	// Retries is the number of additional attempts after the first one failed
+	Retries int
+	// Backoff is the wait before the first retry. It doubles on every attempt.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between two attempts. If the server asks us to
+	// wait longer than that (e.g. because the rate limit is exhausted), we give
+	// up instead.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used unless configured otherwise
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:    3,
+	Backoff:    time.Second,
+	MaxBackoff: time.Minute,
+}
+
+// retryableError marks an error as transient. After optionally holds the
+// minimum wait requested by the server.
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+func retryable(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err, after: after}
+}
+
+// transientGitErrors are messages of git, curl and ssh hinting at network
+// problems
+var transientGitErrors = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"failed to connect",
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"operation timed out",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"returned error: 429",
+	"returned error: 5",
+	"ssh: connect to host",
+}
+
+// gitRetryable marks the error of a git command as retryable if it failed
+// because of the network or ctx timed out, judging by stderr. Other failures,
+// like unknown refs, missing repositories or denied access, are returned as
+// is, as they would fail the same way again.
+func gitRetryable(ctx context.Context, err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return retryable(err, 0)
+	}
+
+	stderr = strings.ToLower(stderr)
+	for _, msg := range transientGitErrors {
+		if strings.Contains(stderr, msg) {
+			return retryable(err, 0)
+		}
+	}
+	return err
+}
+
+// backoff returns the exponential wait before the given retry (0-based)
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	wait := r.Backoff
+	for i := 0; i < retry; i++ {
+		wait *= 2
+		if r.MaxBackoff > 0 && wait > r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	return wait
+}
+
+// Do runs op until it succeeds, returns an error that is not retryable, the
+// retries are exhausted or ctx is done.
+func (r RetryPolicy) Do(ctx context.Context, what string, op func() error) error {
+	for retry := 0; ; retry++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		// cancelled or timed out as a whole: don't bother
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var re *retryableError
+		if !errors.As(err, &re) || retry >= r.Retries {
+			return err
+		}
+
+		wait := r.backoff(retry)
+		if re.after > wait {
+			wait = re.after
+		}
+		if r.MaxBackoff > 0 && wait > r.MaxBackoff {
+			return errors.Wrapf(err, "server asked to retry in %s, which exceeds the maximum backoff", wait)
+		}
+
+		report(ctx, Event{
+			Type:    EventRetry,
+			Message: fmt.Sprintf("%s failed: %s. Retrying in %s (%d/%d)", what, err, wait, retry+1, r.Retries),
+		})
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// retryableStatus returns whether a request that received the given response
+// is worth retrying, and how long the server asked us to wait before doing so.
+// Besides 429 and 5xx this covers GitHub's primary rate limit, which is
+// signaled using a 403 and the X-RateLimit-* headers.
+func retryableStatus(resp *http.Response, now time.Time) (bool, time.Duration) {
+	after := retryAfter(resp.Header, now)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true, after
+	case resp.StatusCode >= 500:
+		return true, after
+	case resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0":
+		return true, after
+	}
+	return false, 0
+}
+
+// retryAfter extracts the wait requested by the server, either from
+// Retry-After (seconds or HTTP date) or from GitHub's X-RateLimit-Reset (unix
+// time) once the rate limit is exhausted.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if t := time.Unix(reset, 0); t.After(now) {
+				return t.Sub(now)
+			}
+		}
+	}
+
+	return 0
+}
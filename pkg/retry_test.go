@@ -0,0 +1,204 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{
+			name:   "None",
+			header: http.Header{},
+			want:   0,
+		},
+		{
+			name:   "Seconds",
+			header: http.Header{"Retry-After": []string{"120"}},
+			want:   2 * time.Minute,
+		},
+		{
+			name:   "Date",
+			header: http.Header{"Retry-After": []string{now.Add(30 * time.Second).Format(http.TimeFormat)}},
+			want:   30 * time.Second,
+		},
+		{
+			name: "GitHubRateLimit",
+			header: http.Header{
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)},
+			},
+			want: 10 * time.Second,
+		},
+		{
+			name: "GitHubRateLimitNotExhausted",
+			header: http.Header{
+				"X-Ratelimit-Remaining": []string{"42"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)},
+			},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryAfter(tt.header, now))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := RetryPolicy{Retries: 10, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, r.backoff(0))
+	assert.Equal(t, 2*time.Second, r.backoff(1))
+	assert.Equal(t, 4*time.Second, r.backoff(2))
+	assert.Equal(t, 5*time.Second, r.backoff(3))
+	assert.Equal(t, 5*time.Second, r.backoff(9))
+}
+
+func TestDownloadGitHubArchiveRetries(t *testing.T) {
+	p := testGitPackage(RetryPolicy{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+
+	tests := []struct {
+		name      string
+		responses []int
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "Transient",
+			responses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			wantCalls: 3,
+		},
+		{
+			name:      "NotFound",
+			responses: []int{http.StatusNotFound},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "Exhausted",
+			responses: []int{500, 502, 503, 504, 200},
+			wantCalls: 4,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.responses[calls])
+				calls++
+			}))
+			defer srv.Close()
+
+			err := p.downloadGitHubArchive(context.Background(), filepath.Join(t.TempDir(), "a.tar.gz"), srv.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
+
+func TestDownloadGitHubArchiveRetryAfterTooLong(t *testing.T) {
+	p := testGitPackage(RetryPolicy{Retries: 3, Backoff: time.Millisecond, MaxBackoff: time.Second})
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	err := p.downloadGitHubArchive(context.Background(), filepath.Join(t.TempDir(), "a.tar.gz"), srv.URL)
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+// erroringGit fails every command with the given message of git
+type erroringGit struct {
+	stderr string
+	calls  *int
+}
+
+func (g erroringGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	*g.calls++
+	fmt.Fprintln(stderr, g.stderr)
+	return errors.New("exit status 128")
+}
+
+func TestGitRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		stderr    string
+		wantCalls int
+	}{
+		{
+			name:      "Network",
+			stderr:    "fatal: unable to access 'https://github.com/foo/bar.git/': Could not resolve host: github.com",
+			wantCalls: 4,
+		},
+		{
+			name:      "ServerError",
+			stderr:    "error: RPC failed; HTTP 502 curl 22 The requested URL returned error: 502",
+			wantCalls: 4,
+		},
+		{
+			name:      "NotFound",
+			stderr:    "remote: Repository not found.\nfatal: repository 'https://github.com/foo/bar.git/' not found",
+			wantCalls: 1,
+		},
+		{
+			name:      "Auth",
+			stderr:    "fatal: Authentication failed for 'https://github.com/foo/bar.git/'",
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			p := testGitPackage(RetryPolicy{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+			p.Git = erroringGit{stderr: tt.stderr, calls: &calls}
+
+			_, err := p.remoteResolveRef(context.Background(), "https://github.com/foo/bar.git", "v1")
+			assert.Error(t, err)
+			assert.Equal(t, tt.wantCalls, calls)
+		})
+	}
+}
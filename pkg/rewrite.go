@@ -0,0 +1,78 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// Rewrite redirects git dependencies to another remote, like insteadOf of
+// git, e.g. to an internal mirror. It only changes where a package is
+// retrieved from, never its name, so vendor paths and imports stay the same.
+type Rewrite struct {
+	// From matches the host/user/repo of a dependency, without scheme. A
+	// trailing * matches any rest, e.g. github.com/*
+	From string
+	// To is the remote to use instead. A * is replaced by the rest matched by
+	// From, e.g. https://git-mirror.corp/github/*.git. Its scheme selects
+	// between https and ssh.
+	To string
+}
+
+// CheckRewrites reports malformed rules
+func CheckRewrites(rules []Rewrite) error {
+	for _, r := range rules {
+		switch {
+		case r.From == "" || r.To == "":
+			return fmt.Errorf("rewrite %q to %q: from and to are required", r.From, r.To)
+		case strings.Contains(strings.TrimSuffix(r.From, "*"), "*"):
+			return fmt.Errorf("rewrite %q: * is only allowed at the end of from", r.From)
+		case strings.Count(r.To, "*") > 1:
+			return fmt.Errorf("rewrite %q to %q: to may contain a single *", r.From, r.To)
+		case strings.Contains(r.To, "*") && !strings.HasSuffix(r.From, "*"):
+			return fmt.Errorf("rewrite %q to %q: to contains * but from does not", r.From, r.To)
+		case strings.Contains(r.From, "://"):
+			return fmt.Errorf("rewrite %q: from must not contain a scheme", r.From)
+		}
+	}
+	return nil
+}
+
+// rewriteRemote returns the remote source is retrieved from. If several rules
+// match, the one with the longest From wins.
+func rewriteRemote(rules []Rewrite, source *deps.Git) string {
+	path := source.Host + "/" + source.User + "/" + source.Repo
+
+	var match *Rewrite
+	rest := ""
+	for i, r := range rules {
+		prefix := strings.TrimSuffix(r.From, "*")
+		wildcard := prefix != r.From
+		if path != r.From && !(wildcard && strings.HasPrefix(path, prefix)) {
+			continue
+		}
+		if match == nil || len(r.From) > len(match.From) {
+			match, rest = &rules[i], strings.TrimPrefix(path, prefix)
+		}
+	}
+
+	if match == nil {
+		return source.Remote()
+	}
+	return strings.Replace(match.To, "*", rest, 1)
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestRewriteRemote(t *testing.T) {
+	rules := []Rewrite{
+		{From: "github.com/*", To: "https://git-mirror.corp/github/*.git"},
+		{From: "github.com/grafana/*", To: "ssh://git@git-mirror.corp/grafana/*.git"},
+		{From: "gitlab.com/foo/bar", To: "https://git-mirror.corp/bar.git"},
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "github.com/foo/bar/lib", want: "https://git-mirror.corp/github/foo/bar.git"},
+		{source: "github.com/grafana/jsonnet-libs/mixin-utils", want: "ssh://git@git-mirror.corp/grafana/jsonnet-libs.git"},
+		{source: "gitlab.com/foo/bar", want: "https://git-mirror.corp/bar.git"},
+		{source: "gitlab.com/foo/baz", want: "https://gitlab.com/foo/baz.git"},
+		{source: "example.com/github.com/foo", want: "https://example.com/github.com/foo.git"},
+	}
+
+	for _, tt := range tests {
+		d := deps.Parse("", tt.source)
+		require.NotNil(t, d, tt.source)
+		assert.Equal(t, tt.want, rewriteRemote(rules, d.Source.GitSource), tt.source)
+	}
+}
+
+func TestCheckRewrites(t *testing.T) {
+	assert.NoError(t, CheckRewrites([]Rewrite{
+		{From: "github.com/*", To: "https://git-mirror.corp/github/*"},
+		{From: "github.com/foo/bar", To: "git@git-mirror.corp:foo/bar.git"},
+	}))
+
+	for _, r := range []Rewrite{
+		{From: "github.com/*"},
+		{From: "github.com/*/bar", To: "https://git-mirror.corp/*"},
+		{From: "github.com/*", To: "https://git-mirror.corp/*/*"},
+		{From: "github.com/foo/bar", To: "https://git-mirror.corp/*"},
+		{From: "https://github.com/*", To: "https://git-mirror.corp/*"},
+	} {
+		assert.Error(t, CheckRewrites([]Rewrite{r}), r.From+" "+r.To)
+	}
+}
+
+func TestInstallRewrite(t *testing.T) {
+	repo := gitRepo(t)
+	lib := *deps.Parse("", "github.com/foo/bar/lib@v1")
+
+	dir := t.TempDir()
+	jf := v1.New()
+	jf.Dependencies.Set(lib.Name(), lib)
+	require.NoError(t, writeJSONFile(filepath.Join(dir, jsonnetfile.File), jf))
+
+	i := Installer{
+		ProjectDir: dir,
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			t.Errorf("unexpected request to %s", r.URL)
+			return nil, errors.New("offline")
+		})},
+		Retry:    &RetryPolicy{},
+		Rewrites: []Rewrite{{From: "github.com/foo/bar", To: "file://" + repo}},
+	}
+
+	res, err := i.Install(context.Background(), nil, InstallOptions{})
+	require.NoError(t, err)
+
+	// only the remote changes, not where the package is vendored
+	assert.FileExists(t, filepath.Join(dir, DefaultVendorDir, "github.com/foo/bar/lib", "main.libsonnet"))
+	d, ok := res.Locked.Get(lib.Name())
+	require.True(t, ok)
+	assert.Equal(t, MethodGit, d.Method)
+	assert.Equal(t, "https://github.com/foo/bar.git", d.Source.GitSource.Remote())
+}
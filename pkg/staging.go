@@ -0,0 +1,122 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// staging records the changes made to vendor/ by a single run, so that a
+// failed run can restore the previous state. Replaced packages are moved
+// into a backup directory below vendor/.tmp instead of being deleted.
+type staging struct {
+	vendorDir string
+	backupDir string
+	changes   []change
+}
+
+// change is a package directory that was (re)placed during a run
+type change struct {
+	path string
+	// backup holds the previous contents of path, if there were any
+	backup string
+}
+
+// tmpDir returns the directory for temporary files below vendorDir
+func tmpDir(vendorDir string) string {
+	return filepath.Join(vendorDir, ".tmp")
+}
+
+// newStaging prepares vendorDir for a new run. Leftovers of crashed runs are
+// removed.
+func newStaging(ctx context.Context, vendorDir string) (*staging, error) {
+	tmp := tmpDir(vendorDir)
+	if _, err := os.Stat(tmp); err == nil {
+		logger(ctx).Infof("removing leftovers of an interrupted run from %s", tmp)
+		if err := os.RemoveAll(tmp); err != nil {
+			return nil, errors.Wrap(err, "removing leftover temporary files")
+		}
+	}
+
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "creating vendor folder")
+	}
+
+	backupDir, err := ioutil.TempDir(tmp, "backup")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating backup folder")
+	}
+
+	return &staging{vendorDir: vendorDir, backupDir: backupDir}, nil
+}
+
+// replace announces that the package at path is about to be installed. A
+// previous version is moved out of the way.
+func (s *staging) replace(path string) error {
+	c := change{path: path}
+
+	if _, err := os.Lstat(path); err == nil {
+		c.backup = filepath.Join(s.backupDir, strconv.Itoa(len(s.changes)))
+		if err := os.Rename(path, c.backup); err != nil {
+			return errors.Wrapf(err, "moving %s out of the way", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	s.changes = append(s.changes, c)
+	return nil
+}
+
+// rollback restores the state before the run, undoing changes in reverse
+// order, as packages may be nested in each other.
+func (s *staging) rollback() error {
+	var errs []string
+	for i := len(s.changes) - 1; i >= 0; i-- {
+		c := s.changes[i]
+		if err := os.RemoveAll(c.path); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if c.backup == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := os.Rename(c.backup, c.path); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	s.changes = nil
+
+	if len(errs) > 0 {
+		return errors.Errorf("restoring %s: %v", s.vendorDir, errs)
+	}
+	return os.RemoveAll(tmpDir(s.vendorDir))
+}
+
+// commit discards the previous versions of all replaced packages
+func (s *staging) commit() error {
+	s.changes = nil
+	return os.RemoveAll(tmpDir(s.vendorDir))
+}
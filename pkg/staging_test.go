@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// offlineGit fails every git command
+type offlineGit struct{}
+
+func (offlineGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	return errors.New("offline")
+}
+
+func TestEnsureRollback(t *testing.T) {
+	dir := t.TempDir()
+	vendorDir := filepath.Join(dir, "vendor")
+	testutil.WriteFiles(t, filepath.Join(dir, "local"), map[string]string{"main.libsonnet": "{}"})
+
+	// an installed package whose files no longer match the lock
+	remote := deps.Dependency{
+		Source:  deps.Source{GitSource: &deps.Git{Scheme: deps.GitSchemeHTTPS, Host: "example.com", User: "foo", Repo: "bar"}},
+		Version: "v2",
+	}
+	testutil.WriteFiles(t, filepath.Join(vendorDir, remote.Name()), map[string]string{"main.libsonnet": "'v1'"})
+	locked := remote
+	locked.Sum = "h1:doesnotmatch"
+
+	local := deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "local"}}}
+
+	before, err := HashDir(filepath.Join(vendorDir, remote.Name()))
+	require.NoError(t, err)
+
+	i := Installer{ProjectDir: dir, VendorDir: vendorDir, Git: offlineGit{}, Retry: &RetryPolicy{}}
+	_, err = i.Ensure(context.Background(),
+		v1.JsonnetFile{Dependencies: addDependencies(deps.NewOrdered(), local, remote)},
+		addDependencies(deps.NewOrdered(), locked),
+	)
+	require.Error(t, err)
+
+	// the new local package is gone, the replaced one is back
+	assert.NoFileExists(t, filepath.Join(vendorDir, local.Name()))
+	after, err := HashDir(filepath.Join(vendorDir, remote.Name()))
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.NoDirExists(t, tmpDir(vendorDir))
+}
+
+func TestEnsureRemovesLeftovers(t *testing.T) {
+	vendorDir := t.TempDir()
+	testutil.WriteFiles(t, filepath.Join(tmpDir(vendorDir), "backup123"), map[string]string{"stale": "x"})
+
+	_, err := Ensure(context.Background(), v1.New(), vendorDir, deps.NewOrdered())
+	require.NoError(t, err)
+
+	assert.NoDirExists(t, tmpDir(vendorDir))
+}
+
+func TestStagingNested(t *testing.T) {
+	vendorDir := t.TempDir()
+	ctx := context.Background()
+	testutil.WriteFiles(t, vendorDir, map[string]string{
+		"repo/main.libsonnet":     "'old'",
+		"repo/sub/main.libsonnet": "'old sub'",
+	})
+	before, err := HashDir(vendorDir)
+	require.NoError(t, err)
+
+	st, err := newStaging(ctx, vendorDir)
+	require.NoError(t, err)
+
+	// replace the parent, then the nested package inside of the new parent
+	require.NoError(t, st.replace(filepath.Join(vendorDir, "repo")))
+	testutil.WriteFiles(t, vendorDir, map[string]string{"repo/sub/main.libsonnet": "'new sub'"})
+	require.NoError(t, st.replace(filepath.Join(vendorDir, "repo/sub")))
+	testutil.WriteFiles(t, vendorDir, map[string]string{"repo/sub/main.libsonnet": "'newer sub'"})
+
+	require.NoError(t, st.rollback())
+
+	after, err := HashDir(vendorDir)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.NoDirExists(t, tmpDir(vendorDir))
+}
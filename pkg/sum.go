@@ -0,0 +1,183 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SumPrefixH1 tags sums computed by HashDir. Sums without a prefix are of
+// the legacy format, which only covers file contents.
+const SumPrefixH1 = "h1:"
+
+// HashDir computes the checksum of a directory in the current format: a
+// sha256 over a sorted manifest holding one line per file, consisting of its
+// mode, the sha256 of its contents and its slash separated path relative to
+// dir:
+//
+//	100644 <sha256 hex> path/to/file.libsonnet
+//	120000 <sha256 hex of the link target> path/to/link
+//
+// Modes are normalized to the ones git knows about (100644, 100755, 120000),
+// so the sum does not depend on the umask. Directories are implied by the
+// files they contain. The result is prefixed with SumPrefixH1.
+func HashDir(dir string) (string, error) {
+	return sumDir(context.Background(), dir)
+}
+
+// sumDir is HashDir, logging every file hashed
+func sumDir(ctx context.Context, dir string) (string, error) {
+	manifest, err := hashManifest(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256([]byte(manifest))
+	return SumPrefixH1 + base64.StdEncoding.EncodeToString(h[:]), nil
+}
+
+// hashManifest returns the manifest HashDir hashes
+func hashManifest(ctx context.Context, dir string) (string, error) {
+	var b strings.Builder
+
+	// Walk visits entries in lexical order, which makes the manifest sorted
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.ContainsAny(rel, "\n\r") {
+			return fmt.Errorf("unsupported file name %q", rel)
+		}
+
+		logger(ctx).Debugf("hashing %s", path)
+		mode, sum, err := hashEntry(path, info)
+		if err != nil {
+			return err
+		}
+		if mode == "" {
+			// neither a file nor a symlink (e.g. a socket): not part of a package
+			return nil
+		}
+
+		fmt.Fprintf(&b, "%s %x %s\n", mode, sum, rel)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// hashEntry returns the normalized mode and content hash of a single file
+func hashEntry(path string, info os.FileInfo) (mode string, sum []byte, err error) {
+	h := sha256.New()
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", nil, err
+		}
+		io.WriteString(h, filepath.ToSlash(target))
+		return "120000", h.Sum(nil), nil
+
+	case info.Mode().IsRegular():
+		f, err := os.Open(path)
+		if err != nil {
+			return "", nil, err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(h, f); err != nil {
+			return "", nil, err
+		}
+
+		mode = "100644"
+		if info.Mode()&0111 != 0 {
+			mode = "100755"
+		}
+		return mode, h.Sum(nil), nil
+	}
+
+	return "", nil, nil
+}
+
+// sumLike computes the checksum of dir in the same format as the given sum,
+// so both can be compared
+func sumLike(ctx context.Context, dir, sum string) (string, error) {
+	if strings.HasPrefix(sum, SumPrefixH1) {
+		return sumDir(ctx, dir)
+	}
+	return hashDir(ctx, dir), nil
+}
+
+// isLegacySum returns whether sum was computed using the legacy format
+func isLegacySum(sum string) bool {
+	return sum != "" && !strings.HasPrefix(sum, SumPrefixH1)
+}
+
+// hashDir computes the checksum of a directory by concatenating all files and
+// hashing this data using sha256. This can be memory heavy with lots of data,
+// but jsonnet files should be fairly small.
+//
+// Deprecated: this is the legacy format, which ignores file names, modes and
+// symlinks. It is only used to verify old lock entries before they are
+// migrated to HashDir.
+func hashDir(ctx context.Context, dir string) string {
+	hasher := sha256.New()
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		logger(ctx).Debugf("hashing %s", path)
+		f, err := os.Open(path)
+		if err != nil {
+			logger(ctx).Debugf("opening %s: %s", path, err)
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(hasher, f); err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+}
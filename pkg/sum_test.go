@@ -0,0 +1,151 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func hashTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, files)
+
+	sum, err := HashDir(dir)
+	require.NoError(t, err)
+	return sum
+}
+
+func TestHashDir(t *testing.T) {
+	base := map[string]string{
+		"main.libsonnet":     "{ a: import 'lib/a.libsonnet' }",
+		"lib/a.libsonnet":    "'a'",
+		"lib/b.libsonnet":    "'b'",
+		"lib/link.libsonnet": "->a.libsonnet",
+	}
+	sum := hashTree(t, base)
+
+	// the format must never change, as it is recorded in lock files
+	assert.Equal(t, "h1:NdsBRyCU0KHyRsoZkO6KNgVfRlBpY289A0cg5fIWEes=", sum)
+	assert.Equal(t, sum, hashTree(t, base), "not deterministic")
+
+	variants := map[string]map[string]string{
+		"Renamed": {
+			"main.libsonnet":     "{ a: import 'lib/a.libsonnet' }",
+			"lib/a.libsonnet":    "'a'",
+			"lib/c.libsonnet":    "'b'",
+			"lib/link.libsonnet": "->a.libsonnet",
+		},
+		"MovedContent": {
+			"main.libsonnet":     "{ a: import 'lib/a.libsonnet' }",
+			"lib/a.libsonnet":    "'a''b'",
+			"lib/b.libsonnet":    "",
+			"lib/link.libsonnet": "->a.libsonnet",
+		},
+		"SymlinkTarget": {
+			"main.libsonnet":     "{ a: import 'lib/a.libsonnet' }",
+			"lib/a.libsonnet":    "'a'",
+			"lib/b.libsonnet":    "'b'",
+			"lib/link.libsonnet": "->b.libsonnet",
+		},
+	}
+
+	for name, files := range variants {
+		t.Run(name, func(t *testing.T) {
+			assert.NotEqual(t, sum, hashTree(t, files))
+		})
+	}
+}
+
+func TestHashDirMode(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{"run.sh": "echo hi"})
+
+	before, err := HashDir(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chmod(filepath.Join(dir, "run.sh"), 0755))
+	after, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	// only the executable bit matters, not the umask
+	require.NoError(t, os.Chmod(filepath.Join(dir, "run.sh"), 0700))
+	umask, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, after, umask)
+}
+
+func TestSumLikeLogsFiles(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{"a.libsonnet": "{}", "lib/b.libsonnet": "{}"})
+
+	for _, sum := range []string{SumPrefixH1, "legacy"} {
+		b := &bytes.Buffer{}
+		ctx := WithLogger(context.Background(), NewLogger(b, LevelDebug))
+		_, err := sumLike(ctx, dir, sum)
+		require.NoError(t, err)
+		assert.Contains(t, b.String(), filepath.Join(dir, "a.libsonnet"), sum)
+		assert.Contains(t, b.String(), filepath.Join(dir, "lib", "b.libsonnet"), sum)
+	}
+}
+
+func TestEnsureMigratesLegacySum(t *testing.T) {
+	vendorDir := t.TempDir()
+
+	d := *deps.Parse("", "github.com/foo/bar@v1")
+	testutil.WriteFiles(t, filepath.Join(vendorDir, d.Name()), map[string]string{"main.libsonnet": "{}"})
+
+	legacy := d
+	legacy.Sum = hashDir(context.Background(), filepath.Join(vendorDir, d.Name()))
+	locks := deps.NewOrdered()
+	locks.Set(d.Name(), legacy)
+
+	direct := deps.NewOrdered()
+	direct.Set(d.Name(), d)
+
+	// the legacy sum matches, so nothing is downloaded
+	i := Installer{VendorDir: vendorDir, Git: failingGit{t}}
+	locked, err := i.Ensure(context.Background(), v1.JsonnetFile{Dependencies: direct}, locks)
+	require.NoError(t, err)
+
+	got, _ := locked.Get(d.Name())
+	want, err := HashDir(filepath.Join(vendorDir, d.Name()))
+	require.NoError(t, err)
+	assert.Equal(t, want, got.Sum)
+}
+
+// failingGit fails the test if any git command is run
+type failingGit struct {
+	t *testing.T
+}
+
+func (g failingGit) Run(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	g.t.Fatalf("unexpected git command: %v", args)
+	return nil
+}
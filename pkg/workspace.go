@@ -0,0 +1,306 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// Workspace is a set of projects sharing one jsonnetfile.lock.json and vendor
+// directory, so they all use the same version of every package
+type Workspace struct {
+	// Dir holds the workspace file, the shared lock and vendor directory
+	Dir string
+	// Members are the absolute directories of the member projects
+	Members []string
+}
+
+// LoadWorkspace reads the workspace file in dir, expanding the member
+// patterns. Every member has to be a directory inside of dir holding a
+// jsonnetfile.
+func LoadWorkspace(dir string) (*Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := jsonnetfile.LoadWorkspace(filepath.Join(dir, jsonnetfile.WorkspaceFile))
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &Workspace{Dir: dir}
+	seen := map[string]bool{}
+	for _, pattern := range f.Members {
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "member %s", pattern)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("member %s matches no directory", pattern)
+		}
+
+		sort.Strings(matches)
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || !fi.IsDir() || seen[m] {
+				continue
+			}
+			if !within(dir, m) {
+				return nil, fmt.Errorf("member %s is outside of the workspace", pattern)
+			}
+
+			file, err := jsonnetfile.Find(m)
+			if err != nil {
+				return nil, err
+			}
+			if exists, err := jsonnetfile.Exists(file); err != nil || !exists {
+				return nil, fmt.Errorf("member %s has no jsonnetfile", relTo(dir, m))
+			}
+
+			seen[m] = true
+			ws.Members = append(ws.Members, m)
+		}
+	}
+	return ws, nil
+}
+
+// FindWorkspace returns the workspace dir is part of, looking for a workspace
+// file in dir and its parents. dir has to be the workspace directory, or be
+// inside of one of its members. Otherwise, nil is returned.
+func FindWorkspace(dir string) (*Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for root := dir; ; root = filepath.Dir(root) {
+		exists, err := jsonnetfile.Exists(filepath.Join(root, jsonnetfile.WorkspaceFile))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			ws, err := LoadWorkspace(root)
+			if err != nil {
+				return nil, err
+			}
+			if root == dir || ws.Member(dir) != "" {
+				return ws, nil
+			}
+			return nil, nil
+		}
+
+		if filepath.Dir(root) == root {
+			return nil, nil
+		}
+	}
+}
+
+// Member returns the member dir belongs to, or an empty string
+func (ws *Workspace) Member(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+
+	// the most specific member wins, members may be nested
+	member := ""
+	for _, m := range ws.Members {
+		if within(m, dir) && len(m) > len(member) {
+			member = m
+		}
+	}
+	return member
+}
+
+// Named returns the member named name, like a local dependency on it would be,
+// i.e. the member with that directory name. An empty string is returned if
+// there is none, or several.
+func (ws *Workspace) Named(name string) string {
+	member := ""
+	for _, m := range ws.Members {
+		if filepath.Base(m) != name {
+			continue
+		}
+		if member != "" {
+			return ""
+		}
+		member = m
+	}
+	return member
+}
+
+// workspaceMember returns a local dependency of the project on the member of
+// the workspace named uri, or nil if there is none
+func (i *Installer) workspaceMember(uri string) *deps.Dependency {
+	if i.WorkspaceDir == "" {
+		return nil
+	}
+	ws, err := LoadWorkspace(i.WorkspaceDir)
+	if err != nil {
+		return nil
+	}
+	m := ws.Named(uri)
+	if m == "" {
+		return nil
+	}
+	project, err := filepath.Abs(i.projectDir())
+	if err != nil {
+		return nil
+	}
+	return deps.Parse(project, relTo(project, m))
+}
+
+// rootDir holds the lock and the vendor directory: the workspace, if any,
+// the project otherwise
+func (i *Installer) rootDir() string {
+	if i.WorkspaceDir != "" {
+		return i.WorkspaceDir
+	}
+	return i.projectDir()
+}
+
+// workspaceRoot returns whether the project is the directory of its
+// workspace, which needs no jsonnetfile of its own
+func (i *Installer) workspaceRoot() bool {
+	if i.WorkspaceDir == "" {
+		return false
+	}
+	project, err := filepath.Abs(i.projectDir())
+	if err != nil {
+		return false
+	}
+	root, err := filepath.Abs(i.WorkspaceDir)
+	return err == nil && project == root
+}
+
+// LockPath returns the path of the jsonnetfile.lock.json used by i, which is
+// shared by all members of a workspace
+func (i *Installer) LockPath() string {
+	return filepath.Join(i.rootDir(), jsonnetfile.LockFile)
+}
+
+// VendorPath returns the directory packages are installed to, which is shared
+// by all members of a workspace
+func (i *Installer) VendorPath() string {
+	return i.vendorDir()
+}
+
+// workspaceDirect returns the dependencies of all members of the workspace,
+// using direct for the project itself. Local dependencies are made relative
+// to the workspace. A local dependency that does not exist next to the member
+// declaring it, but names another member, is wired up to that member. If
+// members declare the same package differently, the project itself wins, then
+// the first member declaring it.
+func (i *Installer) workspaceDirect(ctx context.Context, direct v1.JsonnetFile) (v1.JsonnetFile, error) {
+	ws, err := LoadWorkspace(i.WorkspaceDir)
+	if err != nil {
+		return direct, err
+	}
+
+	project, err := filepath.Abs(i.projectDir())
+	if err != nil {
+		return direct, err
+	}
+
+	union := v1.New()
+	union.LegacyImports = false
+	declared := map[string]string{}
+
+	add := func(dir string, jf v1.JsonnetFile) {
+		union.LegacyImports = union.LegacyImports || jf.LegacyImports
+		for _, k := range jf.Dependencies.Keys() {
+			d, _ := jf.Dependencies.Get(k)
+			if d.Source.LocalSource != nil && !filepath.IsAbs(d.Source.LocalSource.Directory) {
+				local := *d.Source.LocalSource
+				target := filepath.Join(dir, local.Directory)
+				if _, err := os.Stat(target); os.IsNotExist(err) {
+					if m := ws.Named(d.Name()); m != "" && m != dir {
+						logger(ctx).Debugf("%s: %s refers to the member %s", relTo(ws.Dir, dir), local.Directory, relTo(ws.Dir, m))
+						target = m
+					}
+				}
+				local.Directory = relTo(ws.Dir, target)
+				d.Source.LocalSource = &local
+			}
+
+			prev, ok := union.Dependencies.Get(d.Name())
+			if !ok {
+				union.Dependencies.Set(d.Name(), d)
+				declared[d.Name()] = relTo(ws.Dir, dir)
+				continue
+			}
+			if prev.Version != d.Version || !reflect.DeepEqual(prev.Source, d.Source) {
+				report(ctx, Event{
+					Type:    EventWarning,
+					Package: d.Name(),
+					Message: fmt.Sprintf("declared as %s by %s, using %s of %s", describe(d), relTo(ws.Dir, dir), describe(prev), declared[d.Name()]),
+				})
+			}
+		}
+	}
+
+	// the workspace directory itself may hold no jsonnetfile
+	if ws.Member(project) != "" || direct.Dependencies.Len() > 0 {
+		add(project, direct)
+	}
+	for _, m := range ws.Members {
+		if m == project {
+			continue
+		}
+
+		file, err := jsonnetfile.Find(m)
+		if err != nil {
+			return direct, err
+		}
+		jf, err := jsonnetfile.Load(file)
+		if err != nil {
+			return direct, errors.Wrapf(err, "loading %s", relTo(ws.Dir, file))
+		}
+		add(m, jf)
+	}
+
+	return union, nil
+}
+
+// describe returns the version or local directory of d, for humans
+func describe(d deps.Dependency) string {
+	if d.Source.LocalSource != nil {
+		return d.Source.LocalSource.Directory
+	}
+	if d.Version == "" {
+		return "the default branch"
+	}
+	return d.Version
+}
+
+// relTo returns p relative to dir if possible, slash separated
+func relTo(dir, p string) string {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+// writeWorkspace creates a workspace in dir with the members apps/a, apps/b
+// and libs/common, where apps/a depends on libs/common
+func writeWorkspace(t *testing.T, dir string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, jsonnetfile.WorkspaceFile), []byte(`{"version": 1, "members": ["apps/*", "libs/common"]}`), 0644))
+
+	for _, m := range []string{"apps/a", "apps/b", "libs/common"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, m), os.ModePerm))
+	}
+	writeProject(t, filepath.Join(dir, "apps", "a"), `{"version": 1, "dependencies": [{"source": {"local": {"directory": "../../libs/common"}}, "version": ""}], "legacyImports": false}`, "")
+	writeProject(t, filepath.Join(dir, "apps", "b"), `{"version": 1, "dependencies": [], "legacyImports": false}`, "")
+	writeProject(t, filepath.Join(dir, "libs", "common"), `{"version": 1, "dependencies": [], "legacyImports": false}`, "")
+}
+
+func TestLoadWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspace(t, dir)
+
+	ws, err := LoadWorkspace(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "apps", "a"),
+		filepath.Join(dir, "apps", "b"),
+		filepath.Join(dir, "libs", "common"),
+	}, ws.Members)
+
+	assert.Equal(t, filepath.Join(dir, "apps", "a"), ws.Member(filepath.Join(dir, "apps", "a", "lib")))
+	assert.Equal(t, "", ws.Member(filepath.Join(dir, "apps")))
+
+	tests := []struct {
+		name    string
+		members string
+		err     string
+	}{
+		{name: "NoMatch", members: `["apps/c"]`, err: "member apps/c matches no directory"},
+		{name: "Outside", members: `["apps/../.."]`, err: "member apps/../.. is outside of the workspace"},
+		{name: "NoJsonnetfile", members: `["apps"]`, err: "member apps has no jsonnetfile"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, jsonnetfile.WorkspaceFile), []byte(`{"version": 1, "members": `+tc.members+`}`), 0644))
+			_, err := LoadWorkspace(dir)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestFindWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspace(t, dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "apps", "a", "lib"), os.ModePerm))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "tools"), os.ModePerm))
+
+	for _, d := range []string{dir, filepath.Join(dir, "apps", "a"), filepath.Join(dir, "apps", "a", "lib")} {
+		ws, err := FindWorkspace(d)
+		require.NoError(t, err)
+		require.NotNil(t, ws, d)
+		assert.Equal(t, dir, ws.Dir)
+	}
+
+	// no member
+	ws, err := FindWorkspace(filepath.Join(dir, "tools"))
+	require.NoError(t, err)
+	assert.Nil(t, ws)
+}
+
+func TestWorkspaceInstall(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspace(t, dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "apps", "b", "common"), os.ModePerm))
+
+	var rec recorder
+	b := Installer{ProjectDir: filepath.Join(dir, "apps", "b"), WorkspaceDir: dir, Reporter: &rec}
+
+	// installing in b resolves the dependencies of a as well
+	res, err := b.Install(context.Background(), nil, InstallOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"common"}, res.Locked.Keys())
+
+	target, err := os.Readlink(filepath.Join(dir, "vendor", "common"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "libs", "common"), target)
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"common"}, lock.Dependencies.Keys())
+	for _, m := range []string{"apps/a", "apps/b"} {
+		_, err := os.Stat(filepath.Join(dir, m, jsonnetfile.LockFile))
+		assert.True(t, os.IsNotExist(err), m)
+		_, err = os.Stat(filepath.Join(dir, m, "vendor"))
+		assert.True(t, os.IsNotExist(err), m)
+	}
+
+	// a different package of the same name in b wins, as b is changed
+	_, err = b.Install(context.Background(), []string{"common"}, InstallOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, rec.types(), EventWarning)
+
+	target, err = os.Readlink(filepath.Join(dir, "vendor", "common"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "apps", "b", "common"), target)
+
+	// b declares it with its own path
+	jf, err := jsonnetfile.Load(filepath.Join(dir, "apps", "b", jsonnetfile.File))
+	require.NoError(t, err)
+	d, ok := jf.Dependencies.Get("common")
+	require.True(t, ok)
+	assert.Equal(t, "common", d.Source.LocalSource.Directory)
+
+	// the workspace directory needs no jsonnetfile
+	root := Installer{ProjectDir: dir, WorkspaceDir: dir}
+	res, err = root.Install(context.Background(), nil, InstallOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"common"}, res.Locked.Keys())
+	_, err = os.Stat(filepath.Join(dir, jsonnetfile.File))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWorkspaceMemberByName(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspace(t, dir)
+
+	ws, err := LoadWorkspace(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "libs", "common"), ws.Named("common"))
+	assert.Equal(t, "", ws.Named("apps"))
+
+	// a declares common by name only
+	writeProject(t, filepath.Join(dir, "apps", "a"), `{"version": 1, "dependencies": [{"source": {"local": {"directory": "common"}}, "version": ""}], "legacyImports": false}`, "")
+
+	a := Installer{ProjectDir: filepath.Join(dir, "apps", "a"), WorkspaceDir: dir}
+	res, err := a.Install(context.Background(), nil, InstallOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"common"}, res.Locked.Keys())
+
+	target, err := os.Readlink(filepath.Join(dir, "vendor", "common"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "libs", "common"), target)
+
+	// b installs it by name
+	b := Installer{ProjectDir: filepath.Join(dir, "apps", "b"), WorkspaceDir: dir}
+	_, err = b.Install(context.Background(), []string{"common"}, InstallOptions{})
+	require.NoError(t, err)
+
+	jf, err := jsonnetfile.Load(filepath.Join(dir, "apps", "b", jsonnetfile.File))
+	require.NoError(t, err)
+	d, ok := jf.Dependencies.Get("common")
+	require.True(t, ok)
+	assert.Equal(t, "../../libs/common", d.Source.LocalSource.Directory)
+
+	// outside of a workspace, the name means nothing
+	_, err = (&Installer{ProjectDir: filepath.Join(dir, "apps", "b")}).Install(context.Background(), []string{"common"}, InstallOptions{})
+	assert.Error(t, err)
+}
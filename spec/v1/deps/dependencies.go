@@ -21,16 +21,35 @@ import (
 )
 
 type Dependency struct {
-	Source  Source `json:"source"`
+	Source  Source `json:"source" jsonschema:"required"`
 	Version string `json:"version"`
 	Sum     string `json:"sum,omitempty"`
 	Single  bool   `json:"single,omitempty"`
+	// Include and Exclude are glob patterns selecting the files of the
+	// package that are vendored, see Keeps
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Method records how the locked version was retrieved. Only set in lock
+	// files.
+	Method string `json:"method,omitempty"`
+	// Pruned records the files kept by `jb vendor --prune-unreachable`. Only
+	// set in lock files.
+	Pruned *Pruned `json:"pruned,omitempty"`
 
 	// older schema used to have `name`. We still need that data for
 	// `LegacyName`
 	LegacyNameCompat string `json:"name,omitempty"`
 }
 
+// Pruned is the manifest of a package that had its unreachable files removed
+type Pruned struct {
+	// Keep lists the remaining files as slash separated paths relative to
+	// the package
+	Keep []string `json:"keep"`
+	// Sum is the checksum of the remaining files
+	Sum string `json:"sum"`
+}
+
 func Parse(dir, uri string) *Dependency {
 	if uri == "" {
 		return nil
@@ -92,7 +111,7 @@ func (s Source) LegacyName() string {
 }
 
 type Local struct {
-	Directory string `json:"directory"`
+	Directory string `json:"directory" jsonschema:"required"`
 }
 
 func parseLocal(dir, p string) *Dependency {
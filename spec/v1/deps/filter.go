@@ -0,0 +1,126 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deps
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filtered returns whether Include or Exclude are set
+func (d Dependency) Filtered() bool {
+	return len(d.Include) > 0 || len(d.Exclude) > 0
+}
+
+// Keeps returns whether the file at the slash separated path rel, relative to
+// the package, is vendored: it has to match one of the Include patterns, if
+// any, and none of the Exclude patterns.
+func (d Dependency) Keeps(rel string) bool {
+	if len(d.Include) > 0 && !matchAny(d.Include, rel) {
+		return false
+	}
+	return !matchAny(d.Exclude, rel)
+}
+
+// SameFilter returns whether d and o select the same files
+func (d Dependency) SameFilter(o Dependency) bool {
+	return equal(d.Include, o.Include) && equal(d.Exclude, o.Exclude)
+}
+
+// CheckFilter returns an error if one of the patterns is malformed
+func (d Dependency) CheckFilter() error {
+	for _, p := range append(append([]string{}, d.Include...), d.Exclude...) {
+		if err := CheckPattern(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPattern returns an error if pattern is malformed
+func CheckPattern(pattern string) error {
+	if pattern == "" || strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("pattern %q has to be a relative path", pattern)
+	}
+	for _, seg := range strings.Split(pattern, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("malformed pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Match returns whether the slash separated path name, or one of the
+// directories it is in, matches pattern. Like in .gitignore, a pattern
+// without a slash matches a base name at any depth, others match from the
+// root of the package. `**` matches any number of directories.
+func Match(pattern, name string) bool {
+	segs := strings.Split(name, "/")
+	for i := 1; i <= len(segs); i++ {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, segs[i-1]); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(pattern, "/"), segs[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
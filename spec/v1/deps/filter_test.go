@@ -0,0 +1,70 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deps
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "*.md", name: "README.md", want: true},
+		{pattern: "*.md", name: "docs/guide.md", want: true},
+		{pattern: "*.md", name: "main.libsonnet", want: false},
+		{pattern: "tests", name: "tests/fixtures/a.json", want: true},
+		{pattern: "tests/**", name: "tests/fixtures/a.json", want: true},
+		{pattern: "tests/**", name: "lib/tests/a.json", want: false},
+		{pattern: "**/tests/**", name: "lib/tests/a.json", want: true},
+		{pattern: "**/*.libsonnet", name: "main.libsonnet", want: true},
+		{pattern: "**/*.libsonnet", name: "lib/a/b.libsonnet", want: true},
+		{pattern: "**/*.libsonnet", name: "lib/a/b.json", want: false},
+		{pattern: "lib/*.libsonnet", name: "lib/a.libsonnet", want: true},
+		{pattern: "lib/*.libsonnet", name: "lib/a/b.libsonnet", want: false},
+		{pattern: "dashboards", name: "lib/dashboards/a.json", want: true},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, Match(tc.pattern, tc.name), "%s %s", tc.pattern, tc.name)
+	}
+}
+
+func TestKeeps(t *testing.T) {
+	d := Dependency{
+		Include: []string{"**/*.libsonnet", "**/*.json"},
+		Exclude: []string{"tests/**", "dashboards"},
+	}
+	assert.True(t, d.Keeps("main.libsonnet"))
+	assert.True(t, d.Keeps("lib/config.json"))
+	assert.False(t, d.Keeps("README.md"))
+	assert.False(t, d.Keeps("tests/main.libsonnet"))
+	assert.False(t, d.Keeps("lib/dashboards/a.json"))
+
+	// without include, everything not excluded is kept
+	d.Include = nil
+	assert.True(t, d.Keeps("README.md"))
+	assert.False(t, d.Keeps("tests/main.libsonnet"))
+}
+
+func TestCheckFilter(t *testing.T) {
+	assert.NoError(t, Dependency{Include: []string{"**/*.libsonnet"}, Exclude: []string{"tests/**"}}.CheckFilter())
+	assert.EqualError(t, Dependency{Include: []string{"lib/[a-"}}.CheckFilter(), `malformed pattern "lib/[a-"`)
+	assert.EqualError(t, Dependency{Exclude: []string{"/tests"}}.CheckFilter(), `pattern "/tests" has to be a relative path`)
+	assert.EqualError(t, Dependency{Exclude: []string{""}}.CheckFilter(), `pattern "" has to be a relative path`)
+}
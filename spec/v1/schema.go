@@ -0,0 +1,91 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// Schema is the subset of JSON Schema (draft 7) needed to describe a
+// jsonnetfile
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// JSONSchema returns the schema of jsonnetfile.json and jsonnetfile.lock.json.
+// It is generated from the types both are decoded into, so it is always in
+// sync with what jb understands. Unknown keys are not allowed.
+func JSONSchema() *Schema {
+	s := schemaOf(reflect.TypeOf(jsonFile{}))
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = "jsonnetfile"
+	return s
+}
+
+// gitSchema is how deps.Git is encoded, see deps.Git.MarshalJSON
+type gitSchema struct {
+	Remote string `json:"remote" jsonschema:"required"`
+	Subdir string `json:"subdir"`
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(deps.Git{}) {
+		t = reflect.TypeOf(gitSchema{})
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Struct:
+		closed := false
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: &closed}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = schemaOf(f.Type)
+			if f.Tag.Get("jsonschema") == "required" {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	default:
+		return &Schema{}
+	}
+}
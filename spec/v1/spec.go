@@ -44,7 +44,7 @@ func New() JsonnetFile {
 // jsonFile is the json representation of a JsonnetFile, which is different for
 // compatibility reasons.
 type jsonFile struct {
-	Version       uint              `json:"version"`
+	Version       uint              `json:"version" jsonschema:"required"`
 	Dependencies  []deps.Dependency `json:"dependencies"`
 	LegacyImports bool              `json:"legacyImports"`
 }
@@ -16,6 +16,7 @@ package spec
 
 import (
 	"encoding/json"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -116,3 +117,27 @@ func TestRemarshal(t *testing.T) {
 
 	assert.Equal(t, jf, dst)
 }
+
+// TestJSONSchema checks that the schema follows the encoding of the types
+func TestJSONSchema(t *testing.T) {
+	s := JSONSchema()
+	assert.Equal(t, []string{"version"}, s.Required)
+	assert.False(t, *s.AdditionalProperties)
+
+	dep := s.Properties["dependencies"].Items
+	require.NotNil(t, dep)
+	assert.Equal(t, []string{"source"}, dep.Required)
+	assert.Contains(t, dep.Properties, "name")
+	assert.Equal(t, "string", dep.Properties["version"].Type)
+
+	git := dep.Properties["source"].Properties["git"]
+	require.NotNil(t, git)
+	assert.Equal(t, []string{"remote", "subdir"}, func() []string {
+		var keys []string
+		for k := range git.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}())
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+const Version uint = 2
+
+// JsonnetFile is the structure of a version 2 jsonnetfile. Compared to
+// version 1, the import alias of a dependency is called `import` instead of
+// `name`, the package can describe itself and unknown fields are rejected.
+type JsonnetFile struct {
+	// Package describes the package the jsonnetfile belongs to
+	Package *Package
+
+	// List of dependencies. Dependency.LegacyNameCompat holds the import
+	// alias.
+	Dependencies *deps.Ordered
+
+	// Symlink files to their import alias
+	LegacyImports bool
+}
+
+// Package is the metadata of a package
+type Package struct {
+	// Name is how the package calls itself, for humans
+	Name string `json:"name,omitempty"`
+	// Description says what the package is about
+	Description string `json:"description,omitempty"`
+	// License is the SPDX identifier of the license of the package
+	License string `json:"license,omitempty"`
+	// JB is the minimum version of jb required to install the package
+	JB string `json:"jb,omitempty"`
+}
+
+// New returns a new JsonnetFile with the dependencies map initialized
+func New() JsonnetFile {
+	return JsonnetFile{
+		Dependencies:  deps.NewOrdered(),
+		LegacyImports: true,
+	}
+}
+
+// jsonFile is the json representation of a JsonnetFile
+type jsonFile struct {
+	Version       uint             `json:"version"`
+	Package       *Package         `json:"package,omitempty"`
+	Dependencies  []jsonDependency `json:"dependencies"`
+	LegacyImports bool             `json:"legacyImports"`
+}
+
+// jsonDependency is the json representation of a deps.Dependency. It spells
+// out the source, so unknown fields of it are rejected as well.
+type jsonDependency struct {
+	Source  jsonSource `json:"source"`
+	Version string     `json:"version"`
+	Sum     string     `json:"sum,omitempty"`
+	Single  bool       `json:"single,omitempty"`
+	Import  string     `json:"import,omitempty"`
+	Include []string   `json:"include,omitempty"`
+	Exclude []string   `json:"exclude,omitempty"`
+
+	// only set in lock files
+	Method string       `json:"method,omitempty"`
+	Pruned *deps.Pruned `json:"pruned,omitempty"`
+}
+
+type jsonSource struct {
+	Git   *jsonGit    `json:"git,omitempty"`
+	Local *deps.Local `json:"local,omitempty"`
+}
+
+type jsonGit struct {
+	Remote string `json:"remote"`
+	Subdir string `json:"subdir"`
+}
+
+// UnmarshalJSON unmarshals a `jsonFile`'s json into a JsonnetFile. Unlike
+// version 1, unknown fields, dependencies without a source and duplicate
+// dependencies are errors.
+func (jf *JsonnetFile) UnmarshalJSON(data []byte) error {
+	var s jsonFile
+	s.LegacyImports = jf.LegacyImports // adopt default
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return err
+	}
+
+	if s.Package != nil {
+		if err := s.Package.validate(); err != nil {
+			return err
+		}
+	}
+
+	jf.Package = s.Package
+	jf.Dependencies = deps.NewOrdered()
+	for i, j := range s.Dependencies {
+		d, err := j.dependency()
+		if err != nil {
+			return fmt.Errorf("dependency %d: %w", i, err)
+		}
+		if _, ok := jf.Dependencies.Get(d.Name()); ok {
+			return fmt.Errorf("dependency %d: %s is declared more than once", i, d.Name())
+		}
+		jf.Dependencies.Set(d.Name(), d)
+	}
+
+	jf.LegacyImports = s.LegacyImports
+
+	return nil
+}
+
+// MarshalJSON serializes a JsonnetFile into json of the format of a `jsonFile`
+func (jf JsonnetFile) MarshalJSON() ([]byte, error) {
+	var s jsonFile
+
+	s.Version = Version
+	s.Package = jf.Package
+	s.LegacyImports = jf.LegacyImports
+
+	var list []deps.Dependency
+	for _, k := range jf.Dependencies.Keys() {
+		d, _ := jf.Dependencies.Get(k)
+		list = append(list, d)
+	}
+
+	sort.SliceStable(list, func(i int, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+
+	s.Dependencies = make([]jsonDependency, 0, len(list))
+	for _, d := range list {
+		s.Dependencies = append(s.Dependencies, fromDependency(d))
+	}
+
+	return json.Marshal(s)
+}
+
+func (j jsonDependency) dependency() (deps.Dependency, error) {
+	d := deps.Dependency{
+		Version:          j.Version,
+		Sum:              j.Sum,
+		Single:           j.Single,
+		Include:          j.Include,
+		Exclude:          j.Exclude,
+		Method:           j.Method,
+		Pruned:           j.Pruned,
+		LegacyNameCompat: j.Import,
+	}
+
+	switch {
+	case j.Source.Git != nil && j.Source.Local != nil:
+		return d, fmt.Errorf("source has both git and local set")
+	case j.Source.Git != nil:
+		// deps.Git knows how to parse remotes
+		b, err := json.Marshal(j.Source.Git)
+		if err != nil {
+			return d, err
+		}
+		d.Source.GitSource = &deps.Git{}
+		if err := json.Unmarshal(b, d.Source.GitSource); err != nil {
+			return d, err
+		}
+	case j.Source.Local != nil:
+		if j.Source.Local.Directory == "" {
+			return d, fmt.Errorf("local source has no directory")
+		}
+		d.Source.LocalSource = j.Source.Local
+	default:
+		return d, fmt.Errorf("source is missing")
+	}
+
+	if err := validateImport(j.Import); err != nil {
+		return d, fmt.Errorf("%s: %w", d.Name(), err)
+	}
+	if err := d.CheckFilter(); err != nil {
+		return d, fmt.Errorf("%s: %w", d.Name(), err)
+	}
+	return d, nil
+}
+
+func fromDependency(d deps.Dependency) jsonDependency {
+	j := jsonDependency{
+		Version: d.Version,
+		Sum:     d.Sum,
+		Single:  d.Single,
+		Import:  d.LegacyNameCompat,
+		Include: d.Include,
+		Exclude: d.Exclude,
+		Method:  d.Method,
+		Pruned:  d.Pruned,
+	}
+	if g := d.Source.GitSource; g != nil {
+		j.Source.Git = &jsonGit{Remote: g.Remote(), Subdir: strings.TrimPrefix(g.Subdir, "/")}
+	}
+	j.Source.Local = d.Source.LocalSource
+	return j
+}
+
+// validateImport checks that alias is a path inside of the vendor directory
+func validateImport(alias string) error {
+	if alias == "" {
+		return nil
+	}
+	clean := path.Clean(alias)
+	if clean != alias || path.IsAbs(alias) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("import %q is not a clean relative path", alias)
+	}
+	return nil
+}
+
+func (p Package) validate() error {
+	if p.JB == "" {
+		return nil
+	}
+	if _, ok := parseVersion(p.JB); !ok {
+		return fmt.Errorf("package: jb %q is not a version like v0.6.0", p.JB)
+	}
+	return nil
+}
+
+// Requires returns whether the package needs a newer jb than version. Builds
+// without a proper version, like `dev`, are assumed to be new enough.
+func (p Package) Requires(version string) bool {
+	have, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	want, ok := parseVersion(p.JB)
+	if !ok {
+		return false
+	}
+	for i := range want {
+		if want[i] != have[i] {
+			return want[i] > have[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses versions like v1.2.3, ignoring any pre-release or build
+// suffix
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return parsed, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -0,0 +1,265 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+const jsonJF = `{
+  "version": 2,
+  "package": {
+    "name": "my-mixin",
+    "description": "Dashboards and alerts",
+    "license": "Apache-2.0",
+    "jb": "v0.6.0"
+  },
+  "dependencies": [
+    {
+      "source": {
+        "git": {
+          "remote": "https://github.com/grafana/jsonnet-libs.git",
+          "subdir": "grafana-builder"
+        }
+      },
+      "version": "54865853ebc1f901964e25a2e7a0e4d2cb6b9648",
+      "sum": "ELsYwK+kGdzX1mee2Yy+/b2mdO4Y503BOCDkFzwmGbE=",
+      "include": ["**/*.libsonnet"],
+      "exclude": ["tests/**"]
+    },
+    {
+      "source": {
+        "git": {
+          "remote": "https://github.com/prometheus/prometheus.git",
+          "subdir": "documentation/prometheus-mixin"
+        }
+      },
+      "version": "7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684",
+      "sum": "bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY=",
+      "import": "prometheus"
+    },
+    {
+      "source": {
+        "local": {
+          "directory": "lib/utils"
+        }
+      },
+      "version": ""
+    }
+  ],
+  "legacyImports": false
+}`
+
+func testData() JsonnetFile {
+	td := JsonnetFile{
+		Package: &Package{
+			Name:        "my-mixin",
+			Description: "Dashboards and alerts",
+			License:     "Apache-2.0",
+			JB:          "v0.6.0",
+		},
+		LegacyImports: false,
+		Dependencies:  deps.NewOrdered(),
+	}
+	td.Dependencies.Set("github.com/grafana/jsonnet-libs/grafana-builder", deps.Dependency{
+		Source: deps.Source{
+			GitSource: &deps.Git{
+				Scheme: deps.GitSchemeHTTPS,
+				Host:   "github.com",
+				User:   "grafana",
+				Repo:   "jsonnet-libs",
+				Subdir: "/grafana-builder",
+			},
+		},
+		Version: "54865853ebc1f901964e25a2e7a0e4d2cb6b9648",
+		Sum:     "ELsYwK+kGdzX1mee2Yy+/b2mdO4Y503BOCDkFzwmGbE=",
+		Include: []string{"**/*.libsonnet"},
+		Exclude: []string{"tests/**"},
+	})
+	td.Dependencies.Set("github.com/prometheus/prometheus/documentation/prometheus-mixin", deps.Dependency{
+		LegacyNameCompat: "prometheus",
+		Source: deps.Source{
+			GitSource: &deps.Git{
+				Scheme: deps.GitSchemeHTTPS,
+				Host:   "github.com",
+				User:   "prometheus",
+				Repo:   "prometheus",
+				Subdir: "/documentation/prometheus-mixin",
+			},
+		},
+		Version: "7c039a6b3b4b2a9d7c613ac8bd3fc16e8ca79684",
+		Sum:     "bVGOsq3hLOw2irNPAS91a5dZJqQlBUNWy3pVwM4+kIY=",
+	})
+	td.Dependencies.Set("utils", deps.Dependency{
+		Source: deps.Source{
+			LocalSource: &deps.Local{Directory: "lib/utils"},
+		},
+	})
+	return td
+}
+
+// TestUnmarshal checks that unmarshalling works
+func TestUnmarshal(t *testing.T) {
+	dst := New()
+	err := json.Unmarshal([]byte(jsonJF), &dst)
+	require.NoError(t, err)
+	assert.Equal(t, testData(), dst)
+}
+
+// TestMarshal checks that marshalling works
+func TestMarshal(t *testing.T) {
+	data, err := json.Marshal(testData())
+	require.NoError(t, err)
+	assert.JSONEq(t, jsonJF, string(data))
+}
+
+// TestUnmarshalStrict checks that mistakes are not silently ignored
+func TestUnmarshalStrict(t *testing.T) {
+	tests := []struct {
+		Name  string
+		JSON  string
+		Error string
+	}{
+		{
+			Name:  "unknown",
+			JSON:  `{"version": 2, "legacyImport": false}`,
+			Error: `json: unknown field "legacyImport"`,
+		},
+		{
+			Name:  "unknown-package",
+			JSON:  `{"version": 2, "package": {"licence": "MIT"}}`,
+			Error: `json: unknown field "licence"`,
+		},
+		{
+			Name:  "unknown-dependency",
+			JSON:  `{"version": 2, "dependencies": [{"source": {"local": {"directory": "lib"}}, "name": "lib"}]}`,
+			Error: `json: unknown field "name"`,
+		},
+		{
+			Name:  "unknown-git",
+			JSON:  `{"version": 2, "dependencies": [{"source": {"git": {"remote": "https://github.com/foo/bar", "subdirectory": "lib"}}}]}`,
+			Error: `json: unknown field "subdirectory"`,
+		},
+		{
+			Name:  "no-source",
+			JSON:  `{"version": 2, "dependencies": [{"version": "v1"}]}`,
+			Error: "dependency 0: source is missing",
+		},
+		{
+			Name:  "two-sources",
+			JSON:  `{"version": 2, "dependencies": [{"source": {"git": {"remote": "https://github.com/foo/bar"}, "local": {"directory": "lib"}}}]}`,
+			Error: "dependency 0: source has both git and local set",
+		},
+		{
+			Name: "duplicate",
+			JSON: `{"version": 2, "dependencies": [
+				{"source": {"git": {"remote": "https://github.com/foo/bar"}}, "version": "v1"},
+				{"source": {"git": {"remote": "https://github.com/foo/bar.git"}}, "version": "v2"}
+			]}`,
+			Error: "dependency 1: github.com/foo/bar is declared more than once",
+		},
+		{
+			Name:  "import",
+			JSON:  `{"version": 2, "dependencies": [{"source": {"git": {"remote": "https://github.com/foo/bar"}}, "import": "../bar"}]}`,
+			Error: `dependency 0: github.com/foo/bar: import "../bar" is not a clean relative path`,
+		},
+		{
+			Name:  "filter",
+			JSON:  `{"version": 2, "dependencies": [{"source": {"git": {"remote": "https://github.com/foo/bar"}}, "exclude": ["/tests"]}]}`,
+			Error: `dependency 0: github.com/foo/bar: pattern "/tests" has to be a relative path`,
+		},
+		{
+			Name:  "jb",
+			JSON:  `{"version": 2, "package": {"jb": "latest"}}`,
+			Error: `package: jb "latest" is not a version like v0.6.0`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			dst := New()
+			err := json.Unmarshal([]byte(tc.JSON), &dst)
+			assert.EqualError(t, err, tc.Error)
+		})
+	}
+}
+
+// TestFromV1 checks that converting to version 2 and back loses nothing
+func TestFromV1(t *testing.T) {
+	var mv1 v1.JsonnetFile
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "version": 1,
+  "dependencies": [
+    {
+      "name": "prometheus",
+      "source": {"git": {"remote": "https://github.com/prometheus/prometheus.git", "subdir": "documentation/prometheus-mixin"}},
+      "version": "main",
+      "single": true
+    },
+    {
+      "source": {"local": {"directory": "lib/utils"}},
+      "version": ""
+    }
+  ],
+  "legacyImports": true
+}`), &mv1))
+
+	mv2, err := FromV1(mv1)
+	require.NoError(t, err)
+	assert.Nil(t, mv2.Package)
+	assert.True(t, mv2.LegacyImports)
+
+	d, ok := mv2.Dependencies.Get("github.com/prometheus/prometheus/documentation/prometheus-mixin")
+	require.True(t, ok)
+	assert.Equal(t, "prometheus", d.LegacyName())
+	assert.True(t, d.Single)
+
+	data, err := json.Marshal(mv2)
+	require.NoError(t, err)
+
+	again := New()
+	require.NoError(t, json.Unmarshal(data, &again))
+	assert.Equal(t, mv1, again.V1())
+}
+
+func TestRequires(t *testing.T) {
+	tests := []struct {
+		JB       string
+		Version  string
+		Requires bool
+	}{
+		{JB: "", Version: "v0.6.0", Requires: false},
+		{JB: "v0.6.0", Version: "v0.6.0", Requires: false},
+		{JB: "v0.6.0", Version: "v0.5.1", Requires: true},
+		{JB: "v0.6", Version: "v0.6.1", Requires: false},
+		{JB: "v1.0.0", Version: "v0.10.0", Requires: true},
+		{JB: "v0.10.0", Version: "v0.9.3", Requires: true},
+		{JB: "v0.7.0", Version: "v0.7.0-rc.1", Requires: false},
+		{JB: "v0.7.0", Version: "dev", Requires: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.JB+"/"+tc.Version, func(t *testing.T) {
+			assert.Equal(t, tc.Requires, Package{JB: tc.JB}.Requires(tc.Version))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+)
+
+// FromV1 converts a version 1 jsonnetfile. The `name` of a dependency becomes
+// its `import`, everything else is kept as it is.
+func FromV1(mv1 v1.JsonnetFile) (JsonnetFile, error) {
+	m := New()
+	m.LegacyImports = mv1.LegacyImports
+
+	for _, k := range mv1.Dependencies.Keys() {
+		d, _ := mv1.Dependencies.Get(k)
+		if err := validateImport(d.LegacyNameCompat); err != nil {
+			return m, err
+		}
+		m.Dependencies.Set(k, d)
+	}
+
+	return m, nil
+}
+
+// V1 converts jf to version 1, which jb uses internally. Only the package
+// metadata is lost.
+func (jf JsonnetFile) V1() v1.JsonnetFile {
+	mv1 := v1.New()
+	mv1.LegacyImports = jf.LegacyImports
+
+	for _, k := range jf.Dependencies.Keys() {
+		d, _ := jf.Dependencies.Get(k)
+		mv1.Dependencies.Set(k, d)
+	}
+
+	return mv1
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package imports finds the import, importstr and importbin expressions of
+// Jsonnet files. The source is parsed by go-jsonnet, so the content of
+// comments or strings is never mistaken for imports.
+package imports
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// Kind is the keyword of an import
+type Kind string
+
+const (
+	Import    Kind = "import"
+	ImportStr Kind = "importstr"
+	ImportBin Kind = "importbin"
+)
+
+// Ref is a single import expression
+type Ref struct {
+	Kind Kind
+	// Path is the imported path, with all escapes resolved
+	Path string
+	// Offset and End are the byte offsets of the string literal holding
+	// Path, including its quotes
+	Offset, End int
+	// Line is the line the literal starts at, starting at 1
+	Line int
+}
+
+// SyntaxError is returned for source that cannot be parsed
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ScanFile returns all imports of the Jsonnet file name
+func ScanFile(name string) ([]Ref, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := Scan(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return refs, nil
+}
+
+// staticError is implemented by the errors of the go-jsonnet parser
+type staticError interface {
+	error
+	Loc() ast.LocationRange
+}
+
+// Scan returns all imports of the Jsonnet source src, in order of appearance
+func Scan(src []byte) ([]Ref, error) {
+	node, err := jsonnet.SnippetToAST("", string(src))
+	if err != nil {
+		if se, ok := err.(staticError); ok {
+			loc := se.Loc()
+			msg := strings.TrimSpace(strings.TrimPrefix(se.Error(), loc.String()))
+			return nil, &SyntaxError{Line: loc.Begin.Line, Msg: msg}
+		}
+		return nil, err
+	}
+
+	// byte offsets of the start of every line
+	lines := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	offset := func(l ast.Location) int {
+		return lines[l.Line-1] + l.Column - 1
+	}
+
+	var refs []Ref
+	seen := map[int]bool{}
+	walk(node, func(kind Kind, file *ast.LiteralString) {
+		loc := file.Loc()
+		// desugaring may share nodes
+		if start := offset(loc.Begin); !seen[start] {
+			seen[start] = true
+			refs = append(refs, Ref{Kind: kind, Path: file.Value, Offset: start, End: offset(loc.End), Line: loc.Begin.Line})
+		}
+	})
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Offset < refs[j].Offset })
+	return refs, nil
+}
+
+// walk calls f with the file of every import below node
+func walk(node ast.Node, f func(Kind, *ast.LiteralString)) {
+	switch n := node.(type) {
+	case *ast.Import:
+		f(Import, n.File)
+	case *ast.ImportStr:
+		f(ImportStr, n.File)
+	case *ast.ImportBin:
+		f(ImportBin, n.File)
+	}
+
+	for _, c := range toolutils.Children(node) {
+		walk(c, f)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imports
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScan(t *testing.T) {
+	src := `local a = import "a.libsonnet";  // import "comment.libsonnet"
+local b = importstr 'b.txt'; local c = importbin @"c""d.bin";
+# import "hash.libsonnet"
+/* import "block.libsonnet"
+   import "still.libsonnet" */
+{
+  s: "import 'string.libsonnet'",
+  t: |||
+    import "text.libsonnet"
+  |||,
+  e: import "escaped\/x.libsonnet",
+  nested: (import
+    // comments may come in between
+    "multi.libsonnet"),
+  'ü': import 'ü/after-multibyte.libsonnet',
+  importer: 'no',
+}
+`
+	refs, err := Scan([]byte(src))
+	require.NoError(t, err)
+
+	got := []Ref{}
+	for _, r := range refs {
+		// blank the offsets, they are checked below
+		got = append(got, Ref{Kind: r.Kind, Path: r.Path, Line: r.Line})
+	}
+
+	assert.Equal(t, []Ref{
+		{Kind: Import, Path: "a.libsonnet", Line: 1},
+		{Kind: ImportStr, Path: "b.txt", Line: 2},
+		{Kind: ImportBin, Path: `c"d.bin`, Line: 2},
+		{Kind: Import, Path: "escaped/x.libsonnet", Line: 11},
+		{Kind: Import, Path: "multi.libsonnet", Line: 14},
+		{Kind: Import, Path: "ü/after-multibyte.libsonnet", Line: 15},
+	}, got)
+
+	for _, r := range refs {
+		lit := src[r.Offset:r.End]
+		assert.Contains(t, `"'`, lit[len(lit)-1:], lit)
+	}
+	assert.Equal(t, `'b.txt'`, src[refs[1].Offset:refs[1].End])
+	assert.Equal(t, `@"c""d.bin"`, src[refs[2].Offset:refs[2].End])
+	assert.Equal(t, `'ü/after-multibyte.libsonnet'`, src[refs[5].Offset:refs[5].End])
+}
+
+func TestScanErrors(t *testing.T) {
+	cases := map[string]string{
+		"UnterminatedString":    "import 'a.libsonnet",
+		"TrailingBackslash":     `import "a\`,
+		"UnterminatedComment":   "/* import 'a.libsonnet'",
+		"UnterminatedTextBlock": "|||\n  text\n",
+		"UnknownEscape":         `import "\q"`,
+		"TextBlockImport":       "import |||\n  a.libsonnet\n|||",
+		"ComputedImport":        "import 'a' + '.libsonnet'",
+	}
+
+	for name, src := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Scan([]byte(src))
+			var syntaxErr *SyntaxError
+			assert.ErrorAs(t, err, &syntaxErr)
+		})
+	}
+}
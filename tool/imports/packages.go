@@ -0,0 +1,83 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imports
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+// Files lists all Jsonnet files below dir. The directory exclude, usually the
+// vendor directory, is skipped.
+func Files(dir, exclude string) ([]string, error) {
+	var excludeFi os.FileInfo
+	if exclude != "" {
+		fi, err := os.Stat(exclude)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		excludeFi = fi
+	}
+
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if excludeFi != nil && os.SameFile(excludeFi, info) {
+			return filepath.SkipDir
+		}
+
+		if ext := filepath.Ext(path); !info.IsDir() && (ext == ".jsonnet" || ext == ".libsonnet") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// PackageOf returns the package an import path refers to when resolved
+// against the vendor directory. Absolute imports (github.com/foo/bar/x.libsonnet)
+// match the package with the longest name, legacy imports (bar/x.libsonnet)
+// the legacy name of a package, in which case legacy is true.
+func PackageOf(p string, packages *deps.Ordered) (d deps.Dependency, legacy bool, ok bool) {
+	p = path.Clean(filepath.ToSlash(p))
+
+	best := -1
+	for _, k := range packages.Keys() {
+		pkg, _ := packages.Get(k)
+		name := filepath.ToSlash(pkg.Name())
+		if strings.HasPrefix(p, name+"/") && len(name) > best {
+			d, best = pkg, len(name)
+		}
+	}
+	if best >= 0 {
+		return d, false, true
+	}
+
+	first := strings.SplitN(p, "/", 2)[0]
+	for _, k := range packages.Keys() {
+		pkg, _ := packages.Get(k)
+		if pkg.LegacyName() == first {
+			return pkg, true, true
+		}
+	}
+	return deps.Dependency{}, false, false
+}
@@ -0,0 +1,305 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint checks that the imports of Jsonnet files resolve, and that
+// they only refer to packages declared by the importing module
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	"github.com/trevorackerman/jsonnet-bundler/tool/imports"
+)
+
+// Kind classifies a Problem
+type Kind string
+
+const (
+	// Unresolved imports do not resolve to any file
+	Unresolved Kind = "unresolved"
+	// Legacy imports only resolve through the legacy symlink of a package
+	Legacy Kind = "legacy"
+	// Undeclared imports refer to a package the importing module does not
+	// declare in its jsonnetfile.json
+	Undeclared Kind = "undeclared"
+	// Outside imports are relative imports leaving the importing package
+	Outside Kind = "outside"
+	// Unmanaged imports resolve to a file in vendor that is not part of
+	// any package
+	Unmanaged Kind = "unmanaged"
+)
+
+// Problem is an import that does not work, or only works by accident
+type Problem struct {
+	Kind Kind
+	// File holds the import
+	File string
+	imports.Ref
+	// Package is the package the import resolves to, if any
+	Package string
+	// Message describes the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s %q: %s", p.File, p.Line, p.Ref.Kind, p.Path, p.Message)
+}
+
+// Project describes what to lint
+type Project struct {
+	// Dir holds the jsonnetfile.json of the project
+	Dir string
+	// VendorDir holds the installed packages
+	VendorDir string
+	// Locks are all installed packages
+	Locks *deps.Ordered
+	// JPaths are additional library directories of the project, like the
+	// -J flag of jsonnet. They are not used for vendored files.
+	JPaths []string
+	// SkipVendor only checks project files
+	SkipVendor bool
+}
+
+// module is a set of Jsonnet files sharing a jsonnetfile
+type module struct {
+	dir string
+	// file is the jsonnetfile declaring the dependencies
+	file string
+	// name is the package name for vendored modules
+	name     string
+	declared *deps.Ordered
+	vendored bool
+}
+
+// Lint checks all imports of the project, and of the vendored packages unless
+// SkipVendor is set. Project files belong to the module of the closest
+// jsonnetfile.json, vendored ones to their package.
+func Lint(p Project) ([]Problem, error) {
+	l := &linter{Project: p, modules: map[string]*module{}}
+
+	files, err := imports.Files(p.Dir, p.VendorDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		m, err := l.projectModule(filepath.Dir(f))
+		if err != nil {
+			return nil, err
+		}
+		if err := l.lintFile(f, m); err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.SkipVendor {
+		if err := l.lintVendor(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		a, b := l.problems[i], l.problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Offset < b.Offset
+	})
+	return l.problems, nil
+}
+
+type linter struct {
+	Project
+	modules  map[string]*module
+	problems []Problem
+}
+
+// projectModule returns the module of the closest jsonnetfile in or above
+// dir, up to the project directory
+func (l *linter) projectModule(dir string) (*module, error) {
+	if m, ok := l.modules[dir]; ok {
+		return m, nil
+	}
+
+	file, err := jsonnetfile.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+	jf, err := jsonnetfile.Load(file)
+	var m *module
+	switch {
+	case err == nil:
+		m = &module{dir: dir, file: file, declared: jf.Dependencies}
+	case !os.IsNotExist(err):
+		return nil, err
+	case filepath.Clean(dir) == filepath.Clean(l.Dir) || filepath.Dir(dir) == dir:
+		// no jsonnetfile at all
+		m = &module{dir: dir, file: file, declared: deps.NewOrdered()}
+	default:
+		if m, err = l.projectModule(filepath.Dir(dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	l.modules[dir] = m
+	return m, nil
+}
+
+// lintVendor checks the files of all vendored git packages. Local packages
+// are part of the project anyways.
+func (l *linter) lintVendor() error {
+	for _, k := range l.Locks.Keys() {
+		d, _ := l.Locks.Get(k)
+		if d.Source.GitSource == nil {
+			continue
+		}
+
+		dir := filepath.Join(l.VendorDir, d.Name())
+		file, err := jsonnetfile.Find(dir)
+		if err != nil {
+			return err
+		}
+		jf, err := jsonnetfile.Load(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		m := &module{dir: dir, file: file, name: d.Name(), declared: jf.Dependencies, vendored: true}
+
+		files, err := imports.Files(dir, "")
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, f := range files {
+			// nested packages are linted on their own
+			if owner, _, _ := imports.PackageOf(l.rel(f), l.Locks); owner.Name() != d.Name() {
+				continue
+			}
+			if err := l.lintFile(f, m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rel returns the path of the vendored file f relative to the vendor directory
+func (l *linter) rel(f string) string {
+	r, err := filepath.Rel(l.VendorDir, f)
+	if err != nil {
+		return f
+	}
+	return filepath.ToSlash(r)
+}
+
+func (l *linter) report(kind Kind, file string, r imports.Ref, pkg, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{
+		Kind:    kind,
+		File:    file,
+		Ref:     r,
+		Package: pkg,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) lintFile(f string, m *module) error {
+	refs, err := imports.ScanFile(f)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		l.lintImport(f, m, r)
+	}
+	return nil
+}
+
+func (l *linter) lintImport(f string, m *module, r imports.Ref) {
+	if filepath.IsAbs(r.Path) {
+		if !exists(r.Path) {
+			l.report(Unresolved, f, r, "", "cannot be resolved")
+		}
+		return
+	}
+
+	// relative to the importing file
+	if p := filepath.Join(filepath.Dir(f), r.Path); exists(p) {
+		if m.vendored && !within(m.dir, p) {
+			l.report(Outside, f, r, "", "relative import leaves package %s", m.name)
+		}
+		return
+	}
+
+	// library paths of the project
+	if !m.vendored {
+		for _, jpath := range l.JPaths {
+			if exists(filepath.Join(jpath, r.Path)) {
+				return
+			}
+		}
+	}
+
+	if !exists(filepath.Join(l.VendorDir, r.Path)) {
+		l.report(Unresolved, f, r, "", "cannot be resolved")
+		return
+	}
+
+	d, legacy, ok := imports.PackageOf(r.Path, l.Locks)
+	switch {
+	case !ok:
+		l.report(Unmanaged, f, r, "", "resolves to a vendored file that belongs to no package")
+		return
+	case legacy:
+		abs := d.Name() + strings.TrimPrefix(path.Clean(filepath.ToSlash(r.Path)), d.LegacyName())
+		l.report(Legacy, f, r, d.Name(), "only resolves through a legacy symlink, import %q instead", abs)
+	}
+
+	// a package may always import itself
+	if d.Name() == m.name {
+		return
+	}
+	if _, ok := m.declared.Get(d.Name()); !ok {
+		l.report(Undeclared, f, r, d.Name(), "%s is not declared in %s", d.Name(), l.jsonnetfile(m))
+	}
+}
+
+// jsonnetfile returns a readable path to the jsonnetfile of m
+func (l *linter) jsonnetfile(m *module) string {
+	if m.vendored {
+		return filepath.Join(m.name, filepath.Base(m.file))
+	}
+	if r, err := filepath.Rel(l.Dir, m.file); err == nil {
+		return r
+	}
+	return m.file
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// within returns whether p is dir or below it
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func jsonnetFile(t *testing.T, ds ...deps.Dependency) string {
+	t.Helper()
+	jf := v1.New()
+	for _, d := range ds {
+		jf.Dependencies.Set(d.Name(), d)
+	}
+	data, err := json.Marshal(jf)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	vendorDir := filepath.Join(dir, "vendor")
+
+	used := *deps.Parse("", "github.com/foo/used")
+	trans := *deps.Parse("", "github.com/foo/trans")
+	sibling := *deps.Parse("", "github.com/foo/sibling")
+
+	locks := deps.NewOrdered()
+	for _, d := range []deps.Dependency{used, trans, sibling} {
+		locks.Set(d.Name(), d)
+	}
+
+	testutil.WriteFiles(t, dir, map[string]string{
+		"jsonnetfile.json": jsonnetFile(t, used, sibling),
+		"main.jsonnet": `[
+  import 'github.com/foo/used/main.libsonnet',
+  import 'used/main.libsonnet',
+  import 'github.com/foo/trans/main.libsonnet',
+  importstr 'missing.txt',
+  import 'k.libsonnet',
+  import 'local.libsonnet',
+  importbin 'stray.bin',
+]`,
+		"local.libsonnet": "{}",
+		"lib/k.libsonnet": "{}",
+
+		// a sub-module declaring its own dependencies
+		"sub/jsonnetfile.json": jsonnetFile(t, trans),
+		"sub/main.jsonnet":     "import 'github.com/foo/trans/main.libsonnet'",
+
+		"vendor/stray.bin": "",
+		"vendor/github.com/foo/used/jsonnetfile.json": jsonnetFile(t, trans),
+		"vendor/github.com/foo/used/main.libsonnet": `[
+  import 'github.com/foo/trans/main.libsonnet',
+  import 'github.com/foo/used/other.libsonnet',
+  import 'github.com/foo/sibling/main.libsonnet',
+  import '../trans/main.libsonnet',
+]`,
+		"vendor/github.com/foo/used/other.libsonnet":   "{}",
+		"vendor/github.com/foo/trans/main.libsonnet":   "{}",
+		"vendor/github.com/foo/sibling/main.libsonnet": "import 'k.libsonnet'",
+	})
+	require.NoError(t, os.Symlink("github.com/foo/used", filepath.Join(vendorDir, "used")))
+
+	problems, err := Lint(Project{
+		Dir:       dir,
+		VendorDir: vendorDir,
+		Locks:     locks,
+		JPaths:    []string{filepath.Join(dir, "lib")},
+	})
+	require.NoError(t, err)
+
+	type problem struct {
+		kind Kind
+		file string
+		path string
+	}
+	got := []problem{}
+	for _, p := range problems {
+		rel, err := filepath.Rel(dir, p.File)
+		require.NoError(t, err)
+		got = append(got, problem{p.Kind, filepath.ToSlash(rel), p.Path})
+	}
+
+	assert.Equal(t, []problem{
+		{Legacy, "main.jsonnet", "used/main.libsonnet"},
+		{Undeclared, "main.jsonnet", "github.com/foo/trans/main.libsonnet"},
+		{Unresolved, "main.jsonnet", "missing.txt"},
+		{Unmanaged, "main.jsonnet", "stray.bin"},
+		{Unresolved, "vendor/github.com/foo/sibling/main.libsonnet", "k.libsonnet"},
+		{Undeclared, "vendor/github.com/foo/used/main.libsonnet", "github.com/foo/sibling/main.libsonnet"},
+		{Outside, "vendor/github.com/foo/used/main.libsonnet", "../trans/main.libsonnet"},
+	}, got)
+
+	assert.Equal(t, `only resolves through a legacy symlink, import "github.com/foo/used/main.libsonnet" instead`, problems[0].Message)
+	assert.Equal(t, "github.com/foo/trans is not declared in jsonnetfile.json", problems[1].Message)
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prune finds the files of vendored packages that are reachable from
+// the entrypoints of a project
+package prune
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	"github.com/trevorackerman/jsonnet-bundler/tool/imports"
+)
+
+// Project describes where to start from
+type Project struct {
+	// Dir holds the jsonnetfile.json of the project
+	Dir string
+	// VendorDir holds the installed packages
+	VendorDir string
+	// Locks are all installed packages
+	Locks *deps.Ordered
+	// JPaths are additional library directories, like the -J flag of jsonnet
+	JPaths []string
+	// Entrypoints are the files evaluated by jsonnet. Directories stand for
+	// all Jsonnet files below them. If empty, all Jsonnet files of the
+	// project outside of the vendor directory are used.
+	Entrypoints []string
+}
+
+// Result is the outcome of Walk
+type Result struct {
+	// Keep holds the reachable files of every vendored git package, see
+	// Reachable
+	Keep map[string][]string
+	// Unresolved lists the vendored packages that imports which do not
+	// resolve point into, sorted by name. If a package was pruned before,
+	// the files may have been removed while being reachable now.
+	Unresolved []string
+}
+
+// Reachable computes the transitive closure of the imports of the entrypoints.
+// It returns the reachable files of every vendored git package, keyed by
+// package name, as sorted slash separated paths relative to the package.
+// Packages nothing reaches map to an empty list. Files imported using
+// importstr or importbin are reachable, but not scanned for further imports.
+func Reachable(p Project) (map[string][]string, error) {
+	res, err := Walk(p)
+	if err != nil {
+		return nil, err
+	}
+	return res.Keep, nil
+}
+
+// Walk is like Reachable, also reporting the packages of unresolved imports
+func Walk(p Project) (*Result, error) {
+	w := &walker{Project: p, seen: map[string]bool{}, keep: map[string]map[string]bool{}, unresolved: map[string]bool{}}
+	for _, k := range p.Locks.Keys() {
+		d, _ := p.Locks.Get(k)
+		if d.Source.GitSource != nil {
+			w.keep[d.Name()] = map[string]bool{}
+		}
+	}
+
+	entrypoints := p.Entrypoints
+	if len(entrypoints) == 0 {
+		entrypoints = []string{p.Dir}
+	}
+	for _, e := range entrypoints {
+		fi, err := os.Stat(e)
+		if err != nil {
+			return nil, errors.Wrap(err, "entrypoint")
+		}
+		if !fi.IsDir() {
+			w.queue = append(w.queue, e)
+			continue
+		}
+		files, err := imports.Files(e, p.VendorDir)
+		if err != nil {
+			return nil, err
+		}
+		w.queue = append(w.queue, files...)
+	}
+
+	for len(w.queue) > 0 {
+		f := w.queue[0]
+		w.queue = w.queue[1:]
+		if err := w.visit(f, imports.Import); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &Result{Keep: make(map[string][]string, len(w.keep))}
+	for name, files := range w.keep {
+		list := make([]string, 0, len(files))
+		for f := range files {
+			list = append(list, f)
+		}
+		sort.Strings(list)
+		res.Keep[name] = list
+	}
+	for name := range w.unresolved {
+		res.Unresolved = append(res.Unresolved, name)
+	}
+	sort.Strings(res.Unresolved)
+	return res, nil
+}
+
+type walker struct {
+	Project
+	queue      []string
+	seen       map[string]bool
+	keep       map[string]map[string]bool
+	unresolved map[string]bool
+}
+
+// visit marks f as reachable and queues everything it imports
+func (w *walker) visit(f string, kind imports.Kind) error {
+	f = w.canonical(f)
+	if w.seen[f] {
+		return nil
+	}
+	w.seen[f] = true
+
+	w.mark(f)
+	if real, err := filepath.EvalSymlinks(f); err == nil && real != f {
+		w.mark(real)
+	}
+
+	if kind != imports.Import {
+		return nil
+	}
+
+	refs, err := imports.ScanFile(f)
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		p, ok := w.resolve(f, r.Path)
+		if !ok {
+			// reported by jb lint, nothing to keep
+			w.markUnresolved(f, r.Path)
+			continue
+		}
+		if err := w.visit(p, r.Kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve finds the file an import refers to, the same way jsonnet does
+func (w *walker) resolve(from, p string) (string, bool) {
+	for _, c := range w.candidates(from, p) {
+		if exists(c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// candidates returns the files an import may refer to, in the order jsonnet
+// tries them
+func (w *walker) candidates(from, p string) []string {
+	if filepath.IsAbs(p) {
+		return []string{p}
+	}
+
+	candidates := []string{filepath.Join(filepath.Dir(from), p)}
+	for _, jpath := range w.JPaths {
+		candidates = append(candidates, filepath.Join(jpath, p))
+	}
+	return append(candidates, filepath.Join(w.VendorDir, p))
+}
+
+// markUnresolved records the vendored git packages an import that does not
+// resolve may refer to
+func (w *walker) markUnresolved(from, p string) {
+	for _, c := range w.candidates(from, p) {
+		rel, ok := w.rel(filepath.Clean(c))
+		if !ok {
+			continue
+		}
+		if d, _, ok := imports.PackageOf(rel, w.Locks); ok && d.Source.GitSource != nil {
+			w.unresolved[d.Name()] = true
+		}
+	}
+}
+
+// canonical rewrites paths going through the legacy symlink of a package to
+// the package directory, so files are only scanned once
+func (w *walker) canonical(f string) string {
+	f = filepath.Clean(f)
+	rel, ok := w.rel(f)
+	if !ok {
+		return f
+	}
+	d, legacy, ok := imports.PackageOf(rel, w.Locks)
+	if !ok || !legacy {
+		return f
+	}
+	abs := d.Name() + strings.TrimPrefix(rel, d.LegacyName())
+	return filepath.Join(w.VendorDir, filepath.FromSlash(abs))
+}
+
+// mark records f as reachable, if it belongs to a vendored git package
+func (w *walker) mark(f string) {
+	rel, ok := w.rel(f)
+	if !ok {
+		return
+	}
+	d, legacy, ok := imports.PackageOf(rel, w.Locks)
+	if !ok || legacy {
+		return
+	}
+	files, ok := w.keep[d.Name()]
+	if !ok {
+		return
+	}
+	files[strings.TrimPrefix(rel, filepath.ToSlash(d.Name())+"/")] = true
+}
+
+// rel returns the slash separated path of f relative to the vendor
+// directory, if f is inside of it
+func (w *walker) rel(f string) (string, bool) {
+	r, err := filepath.Rel(w.VendorDir, f)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean(filepath.ToSlash(r)), true
+}
+
+func exists(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && !fi.IsDir()
+}
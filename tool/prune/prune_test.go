@@ -0,0 +1,107 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prune
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+func TestReachable(t *testing.T) {
+	dir := t.TempDir()
+	vendorDir := filepath.Join(dir, "vendor")
+
+	used := *deps.Parse("", "github.com/foo/used")
+	trans := *deps.Parse("", "github.com/foo/trans")
+	unused := *deps.Parse("", "github.com/foo/unused")
+	local := deps.Dependency{Source: deps.Source{LocalSource: &deps.Local{Directory: "local"}}}
+
+	locks := deps.NewOrdered()
+	for _, d := range []deps.Dependency{used, trans, unused, local} {
+		locks.Set(d.Name(), d)
+	}
+
+	testutil.WriteFiles(t, dir, map[string]string{
+		"main.jsonnet": `[
+  import 'github.com/foo/used/main.libsonnet',
+  import 'missing.libsonnet',
+]`,
+		"other.jsonnet":   "import 'trans/b.libsonnet'",
+		"pruned.jsonnet":  "import 'github.com/foo/unused/gone.libsonnet'",
+		"lib/k.libsonnet": "import 'github.com/foo/trans/c.libsonnet'",
+
+		"vendor/github.com/foo/used/main.libsonnet": `[
+  import 'util/helpers.libsonnet',
+  importstr 'dashboards/a.json',
+  import 'k.libsonnet',
+]`,
+		"vendor/github.com/foo/used/util/helpers.libsonnet": "import '../../trans/a.libsonnet'",
+		// not scanned, as it is only imported as a string
+		"vendor/github.com/foo/used/dashboards/a.json":  "import 'github.com/foo/unused/x.libsonnet'",
+		"vendor/github.com/foo/used/tests/main.jsonnet": "import '../main.libsonnet'",
+		"vendor/github.com/foo/used/README.md":          "",
+		"vendor/github.com/foo/trans/a.libsonnet":       "{}",
+		"vendor/github.com/foo/trans/b.libsonnet":       "{}",
+		"vendor/github.com/foo/trans/c.libsonnet":       "{}",
+		"vendor/github.com/foo/unused/x.libsonnet":      "{}",
+	})
+	require.NoError(t, os.Symlink("github.com/foo/trans", filepath.Join(vendorDir, "trans")))
+
+	p := Project{
+		Dir:         dir,
+		VendorDir:   vendorDir,
+		Locks:       locks,
+		JPaths:      []string{filepath.Join(dir, "lib")},
+		Entrypoints: []string{filepath.Join(dir, "main.jsonnet")},
+	}
+
+	t.Run("Entrypoint", func(t *testing.T) {
+		keep, err := Reachable(p)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]string{
+			used.Name():   {"dashboards/a.json", "main.libsonnet", "util/helpers.libsonnet"},
+			trans.Name():  {"a.libsonnet", "c.libsonnet"},
+			unused.Name(): {},
+		}, keep)
+	})
+
+	t.Run("Project", func(t *testing.T) {
+		p := p
+		p.Entrypoints = nil
+		keep, err := Reachable(p)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.libsonnet", "b.libsonnet", "c.libsonnet"}, keep[trans.Name()])
+	})
+
+	t.Run("Unresolved", func(t *testing.T) {
+		res, err := Walk(p)
+		require.NoError(t, err)
+		assert.Empty(t, res.Unresolved)
+
+		p := p
+		p.Entrypoints = append(p.Entrypoints, filepath.Join(dir, "pruned.jsonnet"))
+		res, err = Walk(p)
+		require.NoError(t, err)
+		assert.Equal(t, []string{unused.Name()}, res.Unresolved)
+		assert.Empty(t, res.Keep[unused.Name()])
+	})
+}
@@ -17,112 +17,201 @@
 package rewrite
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
+	"github.com/pmezard/go-difflib/difflib"
+
 	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	"github.com/trevorackerman/jsonnet-bundler/tool/imports"
 )
 
-var expr = regexp.MustCompile(`(?mU)(import ["'])(.*)(\/.*["'])`)
+// Renamer returns the new path of an import. ok is false for imports that
+// are kept as they are.
+type Renamer func(path string) (renamed string, ok bool)
 
-// Rewrite changes all imports in `dir` from legacy to absolute style
-// All files in `vendorDir` are ignored
-func Rewrite(dir, vendorDir string, packages *deps.Ordered) error {
-	imports := make(map[string]string)
+// Legacy returns a Renamer changing legacy imports (name/file.libsonnet) of
+// the given packages to absolute ones (github.com/org/name/file.libsonnet)
+func Legacy(packages *deps.Ordered) Renamer {
+	names := make(map[string]string)
 	for _, k := range packages.Keys() {
 		p, _ := packages.Get(k)
 		if p.LegacyName() == p.Name() {
 			continue
 		}
 
-		imports[p.LegacyName()] = p.Name()
+		names[p.LegacyName()] = p.Name()
 	}
 
-	vendorFi, err := os.Stat(filepath.Join(dir, vendorDir))
-	if err != nil {
-		return err
+	return func(path string) (string, bool) {
+		i := strings.Index(path, "/")
+		if i < 0 {
+			return "", false
+		}
+		absolute, ok := names[path[:i]]
+		if !ok {
+			return "", false
+		}
+		return absolute + path[i:], true
 	}
+}
 
-	// list all Jsonnet files
-	files := []string{}
-	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// Move returns a Renamer changing the imports of package from, as resolved
+// against packages, to package to. Legacy imports of from are changed to
+// absolute ones, unless to keeps the same legacy name. Imports of packages
+// nested inside of from are left alone.
+func Move(from, to deps.Dependency, packages *deps.Ordered) Renamer {
+	return func(p string) (string, bool) {
+		d, legacy, ok := imports.PackageOf(p, packages)
+		if !ok || d.Name() != from.Name() {
+			return "", false
 		}
 
-		if os.SameFile(vendorFi, info) {
-			return filepath.SkipDir
+		prefix := filepath.ToSlash(from.Name())
+		if legacy {
+			if from.LegacyName() == to.LegacyName() {
+				return "", false
+			}
+			prefix = from.LegacyName()
 		}
-
-		if ext := filepath.Ext(path); ext == ".jsonnet" || ext == ".libsonnet" {
-			files = append(files, path)
+		if !strings.HasPrefix(p, prefix+"/") {
+			return "", false
 		}
-		return nil
-	}); err != nil {
-		return err
+		return filepath.ToSlash(to.Name()) + strings.TrimPrefix(p, prefix), true
 	}
+}
 
-	// change the imports
-	for _, s := range files {
-		if err := replaceFile(s, imports); err != nil {
-			return err
-		}
+// Source changes the paths of all import, importstr and importbin expressions
+// in the Jsonnet source src using rename. Comments and other strings are left
+// alone. The original string literal is kept where possible.
+func Source(src []byte, rename Renamer) ([]byte, error) {
+	refs, err := imports.Scan(src)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	var out bytes.Buffer
+	last := 0
+	for _, r := range refs {
+		renamed, ok := rename(r.Path)
+		if !ok || renamed == r.Path {
+			continue
+		}
 
-func wrap(s, q string) string {
-	return fmt.Sprintf(`import %s%s`, q, s)
+		out.Write(src[last:r.Offset])
+		out.WriteString(literal(string(src[r.Offset:r.End]), r.Path, renamed))
+		last = r.End
+	}
+	out.Write(src[last:])
+	return out.Bytes(), nil
 }
 
-func replaceFile(name string, imports map[string]string) error {
-	raw, err := ioutil.ReadFile(name)
-	if err != nil {
-		return err
+// literal returns raw, the string literal holding old, with old replaced by
+// renamed. If that cannot be done in place, a new double quoted literal is
+// returned.
+func literal(raw, old, renamed string) string {
+	// only the changed part matters, the rest is copied from raw
+	common := 0
+	for common < len(old) && common < len(renamed) &&
+		old[len(old)-1-common] == renamed[len(renamed)-1-common] {
+		common++
 	}
+	changed := renamed[:len(renamed)-common]
 
-	out := replace(string(raw), imports)
-	return ioutil.WriteFile(name, out, 0644)
+	if strings.Count(raw, old) == 1 && !strings.ContainsAny(changed, "\"'\\\n") {
+		return strings.Replace(raw, old, renamed, 1)
+	}
+
+	// json strings are valid Jsonnet strings
+	b, _ := json.Marshal(renamed)
+	return string(b)
 }
 
-func replace(data string, imports map[string]string) []byte {
-	contents := strings.Split(string(data), "\n")
+// Change is a Jsonnet file whose imports are rewritten
+type Change struct {
+	File   string
+	Before []byte
+	After  []byte
+}
 
-	// try to fix imports line by line
-	buf := make([]string, 0, len(contents))
-	for _, line := range contents {
-		match := expr.FindStringSubmatch(line)
-		// no import in this line: push unmodified
-		if len(match) == 0 {
-			buf = append(buf, line)
-			continue
-		}
+// Diff returns the change as a unified diff
+func (c Change) Diff() string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        lines(c.Before),
+		B:        lines(c.After),
+		FromFile: c.File,
+		ToFile:   c.File,
+		Context:  3,
+	})
+	return diff
+}
 
-		// the legacyName
-		matchedName := match[2]
+// lines splits b into lines, each ending in a newline
+func lines(b []byte) []string {
+	ls := strings.SplitAfter(string(b), "\n")
+	if ls[len(ls)-1] == "" {
+		return ls[:len(ls)-1]
+	}
+	ls[len(ls)-1] += "\n"
+	return ls
+}
 
-		replaced := false
-		for legacy, absolute := range imports {
-			// not this import
-			if matchedName != legacy {
-				continue
-			}
+// Changes computes the rewritten contents of all Jsonnet files in dir, except
+// for the ones in vendorDir. Relative vendorDirs are relative to dir. Files
+// that stay the same are omitted.
+func Changes(dir, vendorDir string, rename Renamer) ([]Change, error) {
+	if !filepath.IsAbs(vendorDir) {
+		vendorDir = filepath.Join(dir, vendorDir)
+	}
+
+	files, err := imports.Files(dir, vendorDir)
+	if err != nil {
+		return nil, err
+	}
 
-			// fix the import
-			replaced = true
-			buf = append(buf, expr.ReplaceAllString(line, "${1}"+absolute+"${3}"))
+	var changes []Change
+	for _, f := range files {
+		before, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		after, err := Source(before, rename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		if bytes.Equal(before, after) {
+			continue
 		}
+		changes = append(changes, Change{File: f, Before: before, After: after})
+	}
+	return changes, nil
+}
 
-		// no matching known import found? push unmodified
-		if !replaced {
-			buf = append(buf, line)
+// Write stores the new contents of all changed files
+func Write(changes []Change) error {
+	for _, c := range changes {
+		fi, err := os.Stat(c.File)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(c.File, c.After, fi.Mode().Perm()); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return []byte(strings.Join(buf, "\n"))
+// Rewrite changes all imports in `dir` from legacy to absolute style
+// All files in `vendorDir` are ignored
+func Rewrite(dir, vendorDir string, packages *deps.Ordered) error {
+	changes, err := Changes(dir, vendorDir, Legacy(packages))
+	if err != nil {
+		return err
+	}
+	return Write(changes)
 }
@@ -22,6 +22,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
 	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
 )
 
@@ -89,3 +90,131 @@ func locks() *deps.Ordered {
 
 	return ls
 }
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			name: "kinds",
+			src:  `[import "ksonnet/a.libsonnet", importstr 'ksonnet/b.txt', importbin "ksonnet/c.bin"]`,
+			want: `[import "github.com/ksonnet/ksonnet/a.libsonnet", importstr 'github.com/ksonnet/ksonnet/b.txt', importbin "github.com/ksonnet/ksonnet/c.bin"]`,
+		},
+		{
+			name: "multiple per line",
+			src:  `(import "ksonnet/a.libsonnet") + (import "prometheus/b.libsonnet")`,
+			want: `(import "github.com/ksonnet/ksonnet/a.libsonnet") + (import "github.com/prometheus/prometheus/b.libsonnet")`,
+		},
+		{
+			name: "comments and strings",
+			src: `// import "ksonnet/a.libsonnet"
+/* import "ksonnet/a.libsonnet" */
+# import "ksonnet/a.libsonnet"
+{ s: 'import "ksonnet/a.libsonnet"' }`,
+		},
+		{
+			name: "text blocks",
+			src: `{
+  doc: |||
+    import "ksonnet/a.libsonnet"
+  |||,
+  lib: import "ksonnet/b.libsonnet",
+}`,
+			want: `{
+  doc: |||
+    import "ksonnet/a.libsonnet"
+  |||,
+  lib: import "github.com/ksonnet/ksonnet/b.libsonnet",
+}`,
+		},
+		{
+			name: "verbatim",
+			src:  `import @'ksonnet/a.libsonnet'`,
+			want: `import @'github.com/ksonnet/ksonnet/a.libsonnet'`,
+		},
+		{
+			name: "escaped",
+			src:  `import "ksonnet\/a.libsonnet"`,
+			want: `import "github.com/ksonnet/ksonnet/a.libsonnet"`,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			want := c.want
+			if want == "" {
+				want = c.src
+			}
+
+			got, err := Source([]byte(c.src), Legacy(locks()))
+			require.NoError(t, err)
+			assert.Equal(t, want, string(got))
+		})
+	}
+}
+
+func TestChanges(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"changed.jsonnet":               `import "ksonnet/a.libsonnet"`,
+		"unchanged.jsonnet":             `import "github.com/ksonnet/ksonnet/a.libsonnet"`,
+		"vendor/ksonnet/main.libsonnet": `import "ksonnet/a.libsonnet"`,
+	}
+	testutil.WriteFiles(t, dir, files)
+
+	changes, err := Changes(dir, "vendor", Legacy(locks()))
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+
+	c := changes[0]
+	assert.Equal(t, filepath.Join(dir, "changed.jsonnet"), c.File)
+	c.File = "changed.jsonnet"
+	assert.Equal(t, `--- changed.jsonnet
++++ changed.jsonnet
+@@ -1 +1 @@
+-import "ksonnet/a.libsonnet"
++import "github.com/ksonnet/ksonnet/a.libsonnet"
+`, c.Diff())
+}
+
+func TestMove(t *testing.T) {
+	from := *deps.Parse("", "github.com/foo/bar")
+	nested := *deps.Parse("", "github.com/foo/bar/sub")
+	packages := deps.NewOrdered()
+	for _, d := range []deps.Dependency{from, nested, *deps.Parse("", "github.com/foo/other")} {
+		packages.Set(d.Name(), d)
+	}
+
+	src := `[
+  import "github.com/foo/bar/main.libsonnet",
+  importstr "github.com/foo/bar/dashboards/a.json",
+  import "github.com/foo/bar/sub/main.libsonnet",
+  import "github.com/foo/barbaz/main.libsonnet",
+  import "github.com/foo/other/main.libsonnet",
+  import "bar/main.libsonnet",
+]`
+
+	// same legacy name: legacy imports keep working
+	got, err := Source([]byte(src), Move(from, *deps.Parse("", "github.com/mirror/bar"), packages))
+	require.NoError(t, err)
+	assert.Equal(t, `[
+  import "github.com/mirror/bar/main.libsonnet",
+  importstr "github.com/mirror/bar/dashboards/a.json",
+  import "github.com/foo/bar/sub/main.libsonnet",
+  import "github.com/foo/barbaz/main.libsonnet",
+  import "github.com/foo/other/main.libsonnet",
+  import "bar/main.libsonnet",
+]`, string(got))
+
+	// new legacy name: legacy imports become absolute
+	got, err = Source([]byte(src), Move(from, *deps.Parse("", "github.com/foo/baz"), packages))
+	require.NoError(t, err)
+	assert.Equal(t, `[
+  import "github.com/foo/baz/main.libsonnet",
+  importstr "github.com/foo/baz/dashboards/a.json",
+  import "github.com/foo/bar/sub/main.libsonnet",
+  import "github.com/foo/barbaz/main.libsonnet",
+  import "github.com/foo/other/main.libsonnet",
+  import "github.com/foo/baz/main.libsonnet",
+]`, string(got))
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tidy compares the dependencies of a project with what its Jsonnet
+// files actually import
+package tidy
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	"github.com/trevorackerman/jsonnet-bundler/tool/imports"
+)
+
+// Import is an import of a package by a project file
+type Import struct {
+	File string
+	imports.Ref
+}
+
+// Transitive is a package the project imports without declaring it
+type Transitive struct {
+	// Dependency is the locked package
+	Dependency deps.Dependency
+	// Imports lists where the project imports the package
+	Imports []Import
+	// RequiredBy lists the packages that declare the dependency
+	RequiredBy []string
+}
+
+// Result lists the differences between declared and imported packages
+type Result struct {
+	// Unused are direct dependencies no project file imports
+	Unused []deps.Dependency
+	// Transitive are packages imported by the project, which are only
+	// present as the dependency of another package
+	Transitive []Transitive
+}
+
+// Analyze scans all Jsonnet files of the project in dir, skipping vendorDir.
+// Imports are resolved relative to the importing file first and against the
+// vendored packages in locks second, like `jsonnet -J vendor` would.
+func Analyze(dir, vendorDir string, direct, locks *deps.Ordered) (*Result, error) {
+	files, err := imports.Files(dir, vendorDir)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string][]Import{}
+	for _, f := range files {
+		refs, err := imports.ScanFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range refs {
+			if filepath.IsAbs(r.Path) {
+				continue
+			}
+			// relative imports take precedence
+			if _, err := os.Stat(filepath.Join(filepath.Dir(f), r.Path)); err == nil {
+				continue
+			}
+
+			d, _, ok := imports.PackageOf(r.Path, locks)
+			if !ok {
+				continue
+			}
+			used[d.Name()] = append(used[d.Name()], Import{File: f, Ref: r})
+		}
+	}
+
+	res := &Result{}
+	for _, k := range direct.Keys() {
+		d, _ := direct.Get(k)
+		if _, ok := used[d.Name()]; !ok {
+			res.Unused = append(res.Unused, d)
+		}
+	}
+
+	requiredBy, err := requirements(vendorDir, locks)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := direct.Get(name); ok {
+			continue
+		}
+		d, _ := locks.Get(name)
+		res.Transitive = append(res.Transitive, Transitive{
+			Dependency: d,
+			Imports:    used[name],
+			RequiredBy: requiredBy[name],
+		})
+	}
+
+	return res, nil
+}
+
+// requirements maps each locked package to the packages depending on it,
+// according to the jsonnetfiles in vendorDir
+func requirements(vendorDir string, locks *deps.Ordered) (map[string][]string, error) {
+	requiredBy := map[string][]string{}
+	for _, k := range locks.Keys() {
+		d, _ := locks.Get(k)
+
+		path, err := jsonnetfile.Find(filepath.Join(vendorDir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		jf, err := jsonnetfile.Load(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dk := range jf.Dependencies.Keys() {
+			dep, _ := jf.Dependencies.Get(dk)
+			requiredBy[dep.Name()] = append(requiredBy[dep.Name()], d.Name())
+		}
+	}
+	return requiredBy, nil
+}
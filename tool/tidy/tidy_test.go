@@ -0,0 +1,83 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tidy
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+)
+
+const mainJsonnet = `
+local used = import 'github.com/foo/used/main.libsonnet';
+local legacy = import 'legacy/main.libsonnet';
+local trans = importstr "github.com/foo/transitive/data.txt";
+// import 'github.com/foo/unused/main.libsonnet'
+local shadowed = import 'shadow/main.libsonnet';
+{}
+`
+
+func TestAnalyze(t *testing.T) {
+	dir := t.TempDir()
+	vendorDir := filepath.Join(dir, "vendor")
+
+	used := *deps.Parse("", "github.com/foo/used")
+	legacy := *deps.Parse("", "github.com/foo/legacy")
+	unused := *deps.Parse("", "github.com/foo/unused")
+	shadow := *deps.Parse("", "github.com/foo/shadow")
+	transitive := *deps.Parse("", "github.com/foo/transitive")
+
+	direct := deps.NewOrdered()
+	for _, d := range []deps.Dependency{used, legacy, unused, shadow} {
+		direct.Set(d.Name(), d)
+	}
+	locks := deps.NewOrdered()
+	for _, d := range []deps.Dependency{used, legacy, unused, shadow, transitive} {
+		locks.Set(d.Name(), d)
+	}
+
+	usedFile := v1.New()
+	usedFile.Dependencies.Set(transitive.Name(), transitive)
+	usedJSON, err := json.Marshal(usedFile)
+	require.NoError(t, err)
+
+	testutil.WriteFiles(t, dir, map[string]string{
+		"main.jsonnet":          mainJsonnet,
+		"shadow/main.libsonnet": "{}",
+		// vendored files are not part of the project
+		"vendor/github.com/foo/unused/main.libsonnet": "import 'github.com/foo/unused/main.libsonnet'",
+		"vendor/github.com/foo/used/jsonnetfile.json": string(usedJSON),
+	})
+
+	res, err := Analyze(dir, vendorDir, direct, locks)
+	require.NoError(t, err)
+
+	assert.Equal(t, []deps.Dependency{unused, shadow}, res.Unused)
+
+	require.Len(t, res.Transitive, 1)
+	tr := res.Transitive[0]
+	assert.Equal(t, transitive, tr.Dependency)
+	assert.Equal(t, []string{used.Name()}, tr.RequiredBy)
+	require.Len(t, tr.Imports, 1)
+	assert.Equal(t, filepath.Join(dir, "main.jsonnet"), tr.Imports[0].File)
+	assert.Equal(t, 4, tr.Imports[0].Line)
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+)
+
+// checkSchema reports everything in the decoded json v that does not match s,
+// which may only use the keywords of v1.Schema. p is the JSON pointer of v.
+func checkSchema(s *v1.Schema, v interface{}, p string, report func(path, msg string)) {
+	if s.Type != "" && !hasType(v, s.Type) {
+		report(p, fmt.Sprintf("expected %s, got %s", article(s.Type), article(typeOf(v))))
+		return
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				report(p, fmt.Sprintf("missing required key %q", key))
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		known := make([]string, 0, len(s.Properties))
+		for key := range s.Properties {
+			known = append(known, key)
+		}
+		sort.Strings(known)
+
+		for _, key := range keys {
+			prop, ok := s.Properties[key]
+			if ok {
+				checkSchema(prop, v[key], pointer(p, key), report)
+				continue
+			}
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				msg := fmt.Sprintf("unknown key %q", key)
+				if c := closest(key, known); c != "" {
+					msg += fmt.Sprintf(", did you mean %q?", c)
+				}
+				report(pointer(p, key), msg)
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return
+		}
+		for i, item := range v {
+			checkSchema(s.Items, item, fmt.Sprintf("%s/%d", p, i), report)
+		}
+	}
+}
+
+func hasType(v interface{}, typ string) bool {
+	switch typ {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	default:
+		return typeOf(v) == typ
+	}
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	default:
+		return "null"
+	}
+}
+
+func article(typ string) string {
+	if strings.IndexAny(typ[:1], "aeiou") == 0 {
+		return "an " + typ
+	}
+	return "a " + typ
+}
+
+// closest returns the candidate most similar to key, if any is close enough
+// to be a typo
+func closest(key string, candidates []string) string {
+	best, bestDist := "", 0
+	for _, c := range candidates {
+		d := distance(strings.ToLower(key), strings.ToLower(c))
+		if d > 2 && !strings.HasPrefix(strings.ToLower(key), strings.ToLower(c)) {
+			continue
+		}
+		if best == "" || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance of a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minimum(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minimum(a int, rest ...int) int {
+	for _, b := range rest {
+		if b < a {
+			a = b
+		}
+	}
+	return a
+}
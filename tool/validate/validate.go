@@ -0,0 +1,307 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validate checks jsonnetfiles for mistakes that loading them
+// silently ignores, like misspelled keys
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trevorackerman/jsonnet-bundler/pkg/jsonnetfile"
+	v1 "github.com/trevorackerman/jsonnet-bundler/spec/v1"
+	"github.com/trevorackerman/jsonnet-bundler/spec/v1/deps"
+	v2 "github.com/trevorackerman/jsonnet-bundler/spec/v2"
+)
+
+// Problem is a mistake in a jsonnetfile
+type Problem struct {
+	// File is the jsonnetfile holding the mistake
+	File string
+	// Path is a JSON pointer to the offending value, like
+	// /dependencies/0/source. Empty for the whole file.
+	Path string
+	// Message describes the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Path, p.Message)
+}
+
+// Project describes what to validate
+type Project struct {
+	// Dir holds the jsonnetfile and the lock of the project
+	Dir string
+	// VendorDir holds the installed packages. Their jsonnetfiles are used to
+	// tell which lock entries are still needed.
+	VendorDir string
+	// LockFile is the lock to check. Defaults to the one in Dir
+	LockFile string
+	// Members are the directories of the other projects of a workspace
+	// sharing LockFile. Their jsonnetfiles are checked as well, and keep the
+	// lock entries they require.
+	Members []string
+}
+
+// Validate checks the jsonnetfile of the project and its lock, if any.
+// Version 1 files are checked against JSONSchema, version 2 files are strict
+// on their own. Besides that, git remotes have to be parsable, dependencies
+// must not be declared twice, local dependencies have to exist and every
+// lock entry has to be required by a dependency.
+func Validate(p Project) ([]Problem, error) {
+	v := &validator{}
+
+	var direct []dependency
+	seen := map[string]bool{}
+	for j, dir := range append([]string{p.Dir}, p.Members...) {
+		if seen[filepath.Clean(dir)] {
+			continue
+		}
+		seen[filepath.Clean(dir)] = true
+
+		file, err := jsonnetfile.Find(dir)
+		if err != nil {
+			return nil, err
+		}
+		// the directory of a workspace needs no jsonnetfile
+		if exists, err := jsonnetfile.Exists(file); j == 0 && len(p.Members) > 0 && err == nil && !exists {
+			continue
+		}
+
+		manifest, err := v.file(file)
+		if err != nil {
+			return nil, err
+		}
+		v.locals(file, dir, manifest)
+		direct = append(direct, manifest...)
+	}
+
+	lockFile := p.LockFile
+	if lockFile == "" {
+		lockFile = filepath.Join(p.Dir, jsonnetfile.LockFile)
+	}
+	if _, err := os.Stat(lockFile); os.IsNotExist(err) {
+		return v.problems, nil
+	}
+	locks, err := v.file(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	required, complete := requiredBy(direct, p.VendorDir)
+	if !complete {
+		// transitive dependencies are unknown
+		return v.problems, nil
+	}
+	for _, l := range locks {
+		if !required[l.Name()] {
+			v.report(lockFile, l.path, "%s is locked, but no dependency requires it, run jb install", l.Name())
+		}
+	}
+
+	return v.problems, nil
+}
+
+// locals checks that the local dependencies of the jsonnetfile at file exist,
+// relative to dir
+func (v *validator) locals(file, dir string, manifest []dependency) {
+	for _, d := range manifest {
+		if d.Source.LocalSource == nil {
+			continue
+		}
+		local := d.Source.LocalSource.Directory
+		if !filepath.IsAbs(local) {
+			local = filepath.Join(dir, local)
+		}
+		if fi, err := os.Stat(local); err != nil || !fi.IsDir() {
+			v.report(file, d.path+"/source/local/directory", "local directory %q does not exist", d.Source.LocalSource.Directory)
+		}
+	}
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) report(file, path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{File: file, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// dependency is a dependency declared at path
+type dependency struct {
+	deps.Dependency
+	path string
+}
+
+// file checks the jsonnetfile at path, returning its parsable dependencies
+func (v *validator) file(path string) ([]dependency, error) {
+	data, err := jsonnetfile.ReadJSON(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			v.report(path, "", "does not exist, run jb init")
+			return nil, nil
+		}
+		v.report(path, "", "%s", err)
+		return nil, nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		v.report(path, "", "invalid json: %s", err)
+		return nil, nil
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		v.report(path, "", "expected an object")
+		return nil, nil
+	}
+
+	switch version := fmt.Sprint(obj["version"]); version {
+	case "<nil>", "0":
+		// version 0 is upgraded by any jb command
+	case "1":
+		checkSchema(v1.JSONSchema(), doc, "", func(p, msg string) { v.report(path, p, "%s", msg) })
+	case "2":
+		// strict on its own, also about remotes and duplicates
+		mv2 := v2.New()
+		if err := json.Unmarshal(data, &mv2); err != nil {
+			v.report(path, "", "%s", err)
+			return nil, nil
+		}
+	default:
+		v.report(path, "/version", "unknown version %s, update jb", version)
+	}
+
+	return v.dependencies(path, obj), nil
+}
+
+// dependencies checks the entries of the dependencies list of the jsonnetfile
+// obj, returning the parsable ones
+func (v *validator) dependencies(path string, obj map[string]interface{}) []dependency {
+	list, _ := obj["dependencies"].([]interface{})
+
+	var ds []dependency
+	seen := map[string]string{}
+	for i, item := range list {
+		p := fmt.Sprintf("/dependencies/%d", i)
+		if _, ok := item.(map[string]interface{}); !ok {
+			continue
+		}
+
+		if g, ok := lookup(item, "source", "git").(map[string]interface{}); ok {
+			b, _ := json.Marshal(g)
+			if err := json.Unmarshal(b, &deps.Git{}); err != nil {
+				v.report(path, p+"/source/git/remote", "cannot parse git remote %q", g["remote"])
+				continue
+			}
+		}
+
+		b, _ := json.Marshal(item)
+		var d deps.Dependency
+		if err := json.Unmarshal(b, &d); err != nil || d.Name() == "" {
+			continue
+		}
+
+		for _, key := range []string{"include", "exclude"} {
+			patterns, _ := lookup(item, key).([]interface{})
+			for j, pattern := range patterns {
+				if s, ok := pattern.(string); ok {
+					if err := deps.CheckPattern(s); err != nil {
+						v.report(path, fmt.Sprintf("%s/%s/%d", p, key, j), "%s", err)
+					}
+				}
+			}
+		}
+
+		if other, ok := seen[d.Name()]; ok {
+			v.report(path, p, "%s is declared more than once, see %s", d.Name(), other)
+			continue
+		}
+		seen[d.Name()] = p
+		ds = append(ds, dependency{Dependency: d, path: p})
+	}
+	return ds
+}
+
+// requiredBy returns the names of the direct dependencies and of everything
+// they require, according to the jsonnetfiles in vendorDir. complete is false
+// if a package is not installed, so its dependencies are unknown.
+func requiredBy(direct []dependency, vendorDir string) (required map[string]bool, complete bool) {
+	required = map[string]bool{}
+	var queue []string
+	for _, d := range direct {
+		queue = append(queue, d.Name())
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if required[name] {
+			continue
+		}
+		required[name] = true
+
+		dir := filepath.Join(vendorDir, name)
+		if _, err := os.Stat(dir); err != nil {
+			return required, false
+		}
+		file, err := jsonnetfile.Find(dir)
+		if err != nil {
+			return required, false
+		}
+		jf, err := jsonnetfile.Load(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return required, false
+		}
+		queue = append(queue, jf.Dependencies.Keys()...)
+	}
+	return required, true
+}
+
+// lookup returns the value at the given keys of nested objects, or nil
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[k]
+	}
+	return v
+}
+
+// pointer appends key to the JSON pointer p
+func pointer(p, key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	key = strings.ReplaceAll(key, "/", "~1")
+	return p + "/" + key
+}
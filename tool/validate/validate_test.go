@@ -0,0 +1,172 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trevorackerman/jsonnet-bundler/internal/testutil"
+)
+
+func messages(dir string, problems []Problem) []string {
+	var msgs []string
+	for _, p := range problems {
+		p.File, _ = filepath.Rel(dir, p.File)
+		msgs = append(msgs, p.String())
+	}
+	return msgs
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{
+		"jsonnetfile.json": `{
+  "version": 1,
+  "dependencies": [
+    {"source": {"git": {"remote": "https://github.com/foo/bar", "subdri": "lib"}}, "version": "main"},
+    {"source": {"git": {"remote": "not a remote"}}, "version": "main"},
+    {"source": {"git": {"remote": "https://github.com/foo/bar.git"}}, "verison": "main"},
+    {"source": {"local": {"directory": "lib/missing"}}, "version": ""},
+    {"source": {"local": {"directory": "lib/utils"}}, "version": ""},
+    {"version": "main"},
+    {"source": {"git": {"remote": "https://github.com/foo/filtered.git"}}, "version": "main", "exclude": ["tests/[a-"]}
+  ],
+  "legacyImports": "yes"
+}`,
+		"lib/utils/main.libsonnet": `{}`,
+	})
+
+	problems, err := Validate(Project{Dir: dir, VendorDir: filepath.Join(dir, "vendor")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`jsonnetfile.json: /dependencies/0/source/git/subdri: unknown key "subdri", did you mean "subdir"?`,
+		`jsonnetfile.json: /dependencies/2/verison: unknown key "verison", did you mean "version"?`,
+		`jsonnetfile.json: /dependencies/5: missing required key "source"`,
+		`jsonnetfile.json: /legacyImports: expected a boolean, got a string`,
+		`jsonnetfile.json: /dependencies/1/source/git/remote: cannot parse git remote "not a remote"`,
+		`jsonnetfile.json: /dependencies/2: github.com/foo/bar is declared more than once, see /dependencies/0`,
+		`jsonnetfile.json: /dependencies/6/exclude/0: malformed pattern "tests/[a-"`,
+		`jsonnetfile.json: /dependencies/3/source/local/directory: local directory "lib/missing" does not exist`,
+	}, messages(dir, problems))
+}
+
+func TestValidateLock(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{
+		"jsonnetfile.json": `{"version": 1, "dependencies": [
+			{"source": {"git": {"remote": "https://github.com/foo/direct.git", "subdir": ""}}, "version": "main"}
+		], "legacyImports": false}`,
+		"jsonnetfile.lock.json": `{"version": 1, "dependencies": [
+			{"source": {"git": {"remote": "https://github.com/foo/direct.git", "subdir": ""}}, "version": "abc", "sum": "h1:x"},
+			{"source": {"git": {"remote": "https://github.com/foo/trans.git", "subdir": ""}}, "version": "abc", "sum": "h1:x"},
+			{"source": {"git": {"remote": "https://github.com/foo/orphan.git", "subdir": ""}}, "version": "abc", "sum": "h1:x", "sums": "h1:x"}
+		], "legacyImports": false}`,
+		"vendor/github.com/foo/direct/jsonnetfile.json": `{"version": 1, "dependencies": [
+			{"source": {"git": {"remote": "https://github.com/foo/trans.git", "subdir": ""}}, "version": "main"}
+		]}`,
+		"vendor/github.com/foo/trans/main.libsonnet": `{}`,
+	})
+
+	problems, err := Validate(Project{Dir: dir, VendorDir: filepath.Join(dir, "vendor")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`jsonnetfile.lock.json: /dependencies/2/sums: unknown key "sums", did you mean "sum"?`,
+		`jsonnetfile.lock.json: /dependencies/2: github.com/foo/orphan is locked, but no dependency requires it, run jb install`,
+	}, messages(dir, problems))
+
+	// without the packages, transitive dependencies are unknown
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "vendor")))
+	problems, err = Validate(Project{Dir: dir, VendorDir: filepath.Join(dir, "vendor")})
+	require.NoError(t, err)
+	assert.Len(t, problems, 1)
+}
+
+func TestValidateWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{
+		"a/jsonnetfile.json": `{"version": 1, "dependencies": [
+			{"source": {"local": {"directory": "../b"}}, "version": ""}
+		], "legacyImports": false}`,
+		"b/jsonnetfile.json": `{"version": 1, "dependencies": [
+			{"source": {"git": {"remote": "https://github.com/foo/direct.git", "subdir": ""}}, "version": "main"},
+			{"source": {"local": {"directory": "missing"}}, "version": ""}
+		], "legacyImports": false}`,
+		"jsonnetfile.lock.json": `{"version": 1, "dependencies": [
+			{"source": {"local": {"directory": "b"}}, "version": ""},
+			{"source": {"git": {"remote": "https://github.com/foo/direct.git", "subdir": ""}}, "version": "abc", "sum": "h1:x"},
+			{"source": {"git": {"remote": "https://github.com/foo/orphan.git", "subdir": ""}}, "version": "abc", "sum": "h1:x"}
+		], "legacyImports": false}`,
+		"vendor/github.com/foo/direct/main.libsonnet": `{}`,
+		"vendor/github.com/foo/orphan/main.libsonnet": `{}`,
+		"vendor/missing/main.libsonnet":               `{}`,
+		"vendor/b/jsonnetfile.json":                   `{"version": 1, "dependencies": []}`,
+	})
+
+	// the workspace directory needs no jsonnetfile, the lock is shared
+	members := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	problems, err := Validate(Project{
+		Dir:       dir,
+		VendorDir: filepath.Join(dir, "vendor"),
+		LockFile:  filepath.Join(dir, "jsonnetfile.lock.json"),
+		Members:   members,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`b/jsonnetfile.json: /dependencies/1/source/local/directory: local directory "missing" does not exist`,
+		`jsonnetfile.lock.json: /dependencies/2: github.com/foo/orphan is locked, but no dependency requires it, run jb install`,
+	}, messages(dir, problems))
+}
+
+func TestValidateFormats(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteFiles(t, dir, map[string]string{
+		"jsonnetfile.yaml": `version: 1
+dependencies:
+  - source:
+      git:
+        remote: https://github.com/foo/bar
+        subdir: lib
+    verison: main
+`,
+	})
+
+	problems, err := Validate(Project{Dir: dir, VendorDir: filepath.Join(dir, "vendor")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`jsonnetfile.yaml: /dependencies/0/verison: unknown key "verison", did you mean "version"?`,
+	}, messages(dir, problems))
+
+	testutil.WriteFiles(t, dir, map[string]string{
+		"jsonnetfile.json": `{"version": 2, "dependencies": [{"source": {"local": {"directory": "lib"}}, "name": "lib"}]}`,
+	})
+	problems, err = Validate(Project{Dir: dir, VendorDir: filepath.Join(dir, "vendor")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`jsonnetfile.json: json: unknown field "name"`,
+	}, messages(dir, problems))
+}
+
+func TestClosest(t *testing.T) {
+	known := []string{"dependencies", "legacyImports", "version"}
+	assert.Equal(t, "dependencies", closest("dependencys", known))
+	assert.Equal(t, "legacyImports", closest("legacyimports", known))
+	assert.Equal(t, "version", closest("versions", known))
+	assert.Equal(t, "", closest("sources", known))
+}